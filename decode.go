@@ -1,9 +1,16 @@
 package ini
 
 import (
+	"bufio"
+	"encoding"
+	"errors"
 	"fmt"
+	"io"
 	"reflect"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // An UnmarshalTypeError describes a value that was not appropriate for a value
@@ -32,13 +39,42 @@ func (e DecodeError) Error() string {
 	return e.err.Error()
 }
 
+// Unmarshaler is the interface implemented by types that can unmarshal a
+// section of an INI document themselves. UnmarshalINI is called with the
+// section, from which every key and duplicate value can be read, in place of
+// decoding the section into the fields of a struct.
+type Unmarshaler interface {
+	UnmarshalINI(s *Section) error
+}
+
+// KeyUnmarshaler is the interface implemented by types that can unmarshal a
+// property key of an INI document themselves. UnmarshalINIKey is called with
+// the key, from which every duplicate value and subkey can be read, in place
+// of decoding the values of the key according to the type.
+type KeyUnmarshaler interface {
+	UnmarshalINIKey(k *Key) error
+}
+
 // Unmarshal parses the INI-encoded data and stores the result in the value
-// pointed to by v. If v is nil or not a pointer to a struct, Unmarshal returns
-// an UnmarshalTypeError; INI-encoded data must be encoded into a struct.
+// pointed to by v. If v is nil or not a pointer to a struct or a map with
+// string keys, Unmarshal returns a DecodeError. If the data is malformed,
+// Unmarshal returns a *SyntaxError.
 //
 // Unmarshal uses the inverse of the encodings that Marshal uses, following the
 // rules below:
 //
+// Spaces and tabs surrounding section names, property keys, subkeys and values
+// are trimmed, so that "key = value" assigns "value" to "key", unless parsing
+// with Options.KeepWhitespace.
+//
+// A value enclosed in single or double quotes is unquoted, preserving any
+// whitespace inside the quotes, and its escape sequences are replaced: \n, \t
+// and \r stand for a newline, tab and carriage return, \", \' and \\ for the
+// quote or backslash, and \uXXXX for the Unicode code point with the given
+// hexadecimal value. A value in which anything but whitespace follows the
+// closing quote, such as "/usr/bin/foo" --bar, is read as written, quotes
+// included.
+//
 // So-called "global" property keys are matched to a struct field within v,
 // either by its field name or tag. Values are then decoded according to the
 // type of the destination field.
@@ -47,6 +83,84 @@ func (e DecodeError) Error() string {
 // name to a struct field name or tag. Subsequent property keys are then matched
 // against struct field names or tags within the struct.
 //
+// A struct field whose section or property key is absent from the data is left
+// unchanged. If the field tag has the "required" option, the section or key
+// must be present; Unmarshal decodes everything else it can, and then returns
+// a *MissingError for each required item that was absent, joined together by
+// errors.Join. A required key is only checked if its section is present.
+//
+// The "default=" option of a field tag gives the value that Unmarshal decodes
+// into the field if its property key is absent, including when its section is
+// absent. Since it may contain commas, it must be the last option of the tag.
+// The default of a slice is a comma-separated list of values, and that of a
+// map a comma-separated list of subkey=value pairs:
+//
+//	Port   int               `ini:"port,default=5432"`
+//	Hosts  []string          `ini:"host,default=db1,db2"`
+//	Shells map[string]string `ini:"shell,default=unix=/bin/sh,win32=cmd.exe"`
+//
+// Section names and property keys match struct fields exactly, unless
+// decoding with Options.CaseInsensitive, which ignores case. A field without a
+// name in its tag is matched by its field name, or by the name derived from it
+// by Options.FieldNaming, such as SnakeCase, if set.
+//
+// Sections and property keys that no struct field matches are ignored, unless
+// decoding with Options.DisallowUnknownSections or Options.DisallowUnknownKeys.
+// Each such item is then reported as an *UnknownError, giving its position, in
+// the same joined error.
+//
+// A struct field of a section struct that is itself a struct is matched to the
+// section nested below it, named by the section name, a dot, and the field name
+// or tag, to any depth. A nested section may instead be named in the quoted
+// form, so that both [server.tls] and [server "tls"] match the field "tls" of
+// the section "server". A section that holds nothing but nested sections may
+// be omitted.
+//
+// A map of structs with string keys is matched to every section found directly
+// below its field name or tag, keyed by the subsection name, so that the
+// sections [remote "origin"] and [remote "upstream"] decode into the elements
+// "origin" and "upstream" of the field "remote". A quoted subsection name is a
+// single key even if it contains a dot, as [branch "v1.2"] does; the sections
+// nested below an element follow the closing quote, as in [remote "origin".tls].
+//
+// time.Duration values are parsed by time.ParseDuration; if the field tag has
+// the "seconds" option, a plain number of seconds is also accepted. time.Time
+// values are parsed in the layout given by the "layout=" option of the field
+// tag, or in the RFC 3339 format by default.
+//
+// If the destination field of a section implements the Unmarshaler interface,
+// Unmarshal calls its UnmarshalINI method with the section. If the destination
+// field of a property key implements the KeyUnmarshaler interface, Unmarshal
+// calls its UnmarshalINIKey method with the key, if the key is present.
+//
+// If the destination field, slice element or map value implements the
+// encoding.TextUnmarshaler interface, with either a pointer or a value
+// receiver, Unmarshal calls its UnmarshalText method with the property value.
+// A struct that implements encoding.TextUnmarshaler, such as time.Time, is
+// decoded from a property value rather than from a section.
+//
+// To unmarshal INI into a map with string keys, Unmarshal stores each section
+// in the map, keyed by section name; the global section is keyed by the empty
+// string, and is stored only if it has any properties. A section decodes into
+// a map with string keys, keyed by property key, in which a property assigned
+// to a subkey is keyed by the key and the subkey in brackets, as in
+// "shell[unix]". A map value of a slice type holds every value assigned to the
+// key, and any other type holds the first. A repeated section adds its
+// properties to the same map, or appends a new map if the section is decoded
+// into a slice of maps, such as a map[string][]map[string]string.
+//
+// To unmarshal INI into an interface value, or into a map with interface
+// values, Unmarshal stores one of these in the interface value, as
+// encoding/json does:
+//
+//	map[string]interface{}, for a section
+//	[]interface{}, for a repeated section or for a key assigned more than one value
+//	string, for a key assigned a single value
+//
+// A key with subkeys is stored as a nested map[string]interface{}, keyed by
+// subkey, in which any values assigned to the key itself are keyed by the
+// empty string.
+//
 // If a duplicate section name or property key is encountered, Unmarshal will
 // allocate a slice according to the number of duplicate keys found, and append
 // each value to the slice. If the destination struct field is not a slice type,
@@ -55,12 +169,23 @@ func (e DecodeError) Error() string {
 // A struct field tag name may be a single asterisk (colloquially known as the
 // "wildcard" character). If such a tag is detected and the destination
 // field is a slice of structs, all sections are decoded into the destination
-// field as an element in the slice. If a struct field named "ININame" is
-// encountered, the section name decoded into that field.
+// field as an element in the slice, in the order they appear. If a struct field
+// named "ININame" is encountered, the section name decoded into that field.
+//
+// More generally, a section tag name containing an asterisk is a pattern, in
+// which each asterisk matches any sequence of characters, such as "program:*"
+// or "profile *". The sections matching a pattern decode into a slice of
+// structs, in the order they appear, or into a map of structs, keyed by the
+// part of the section name matched by the first asterisk. That part of the
+// name, or the subsection name of a map element, is also decoded into a
+// struct field named "ININameMatch", if the element has one; the field may be
+// tagged "-" to leave it out of the encoding.
 //
-// A struct field may be declared as a pointer to a type. If this is the case,
-// the field's value is set to nil if no such key name is found in the
-// INI-encoded data.
+// A struct field, slice element or map value may be declared as a pointer to
+// a type, including a pointer to a pointer. Unmarshal allocates a new value for
+// each nil pointer it decodes into, but only if the corresponding property key
+// or section is present in the INI-encoded data; otherwise the field is left
+// nil, so that an absent key can be told apart from one set to the zero value.
 func Unmarshal(data []byte, v interface{}) error {
 	return unmarshal(data, v, Options{})
 }
@@ -73,149 +198,561 @@ func UnmarshalWithOptions(data []byte, v interface{}, opts Options) error {
 
 func unmarshal(data []byte, v interface{}, opts Options) error {
 	p := newParser(data)
-	p.l.opts.allowMultilineEscapeNewline = opts.AllowMultilineValues
-	p.l.opts.allowMultilineWhitespacePrefix = opts.AllowMultilineValues
-	p.l.opts.allowNumberSignComments = opts.AllowNumberSignComments
-	p.l.opts.allowEmptyValues = opts.AllowEmptyValues
+	p.l.opts = opts.lexerOptions()
 	if err := p.parse(); err != nil {
 		return err
 	}
 
-	return decode(p.tree, reflect.ValueOf(v))
+	ds := decodeState{opts: opts}
+	return ds.decode(p.tree, reflect.ValueOf(v))
+}
+
+// A Decoder reads and decodes an INI document from an input stream.
+type Decoder struct {
+	r    *bufio.Reader
+	name string
+	opts Options
+}
+
+// NewDecoder returns a new decoder that reads from r. The input is read a line
+// at a time as it is parsed, rather than all at once. If r has a Name method,
+// as an *os.File does, syntax errors refer to the input by that name.
+func NewDecoder(r io.Reader) *Decoder {
+	d := Decoder{
+		r: bufio.NewReader(r),
+	}
+	if n, ok := r.(interface{ Name() string }); ok {
+		d.name = n.Name()
+	}
+	return &d
+}
+
+// SetOptions allows decoding behavior to be configured with an Options value.
+// The options apply to every subsequent call to Decode.
+func (d *Decoder) SetOptions(opts Options) {
+	d.opts = opts
+}
+
+// Decode reads the remainder of the input as a single INI-encoded document and
+// stores the result in the value pointed to by v. If the input is empty,
+// Decode returns io.EOF. If reading the input fails, Decode returns the error
+// from the underlying reader.
+//
+// See the documentation for Unmarshal for details about the conversion of INI
+// into a Go value.
+func (d *Decoder) Decode(v interface{}) error {
+	p := newReaderParser(d.r)
+	p.l.name = d.name
+	p.l.opts = d.opts.lexerOptions()
+	err := p.parse()
+	if p.l.err != nil {
+		return p.l.err
+	}
+	if err != nil {
+		return err
+	}
+	if p.l.base+len(p.l.input) == 0 {
+		return io.EOF
+	}
+
+	ds := decodeState{opts: d.opts}
+	return ds.decode(p.tree, reflect.ValueOf(v))
+}
+
+// A MissingError describes a section or property key that is required by the
+// tag of the struct field it decodes into, but is absent from the INI-encoded
+// data.
+type MissingError struct {
+	Section string // name of the section, or of the section holding Key
+	Key     string // name of the property key, or empty for a section
+}
+
+func (e *MissingError) Error() string {
+	if e.Key == "" {
+		return "ini: missing required section " + strconv.Quote(e.Section)
+	}
+	if e.Section == "" {
+		return "ini: missing required key " + strconv.Quote(e.Key)
+	}
+	return "ini: missing required key " + strconv.Quote(e.Key) + " in section " + strconv.Quote(e.Section)
+}
+
+// An UnknownError describes a section or property key of the INI-encoded data
+// that no struct field matches, reported when decoding with
+// Options.DisallowUnknownSections or Options.DisallowUnknownKeys.
+type UnknownError struct {
+	Pos     Position // position of the section header or property key
+	Section string   // name of the section, or of the section holding Key
+	Key     string   // name of the property key, or empty for a section
+}
+
+func (e *UnknownError) Error() string {
+	if e.Key == "" {
+		return e.Pos.String() + ": unknown section " + strconv.Quote(e.Section)
+	}
+	if e.Section == "" {
+		return e.Pos.String() + ": unknown key " + strconv.Quote(e.Key)
+	}
+	return e.Pos.String() + ": unknown key " + strconv.Quote(e.Key) + " in section " + strconv.Quote(e.Section)
+}
+
+// A decodeState holds the state of decoding a single parse tree into a Go
+// value.
+type decodeState struct {
+	opts    Options
+	missing []error                // a *MissingError for each required item not found
+	unknown []*UnknownError        // each unknown item found, if disallowed by opts
+	seen    map[*section]bool      // the sections matched by a struct field
+	tags    map[reflect.Type][]tag // the field tags of each struct type decoded
 }
 
 // decode sets the underlying values of the fields of the value to which rv
-// points to the parsed values stored in the corresponding field of tree. It
+// points to the parsed values stored in the corresponding field of tree. If a
+// required section or property key is missing, or an unknown one is found
+// while d.opts disallows it, decode returns every *MissingError, followed by
+// every *UnknownError in the order they appear, joined by errors.Join. It
 // panics if rv is not a reflect.Ptr to a struct.
-func decode(tree parseTree, rv reflect.Value) error {
+func (d *decodeState) decode(tree parseTree, rv reflect.Value) error {
+	if !rv.IsValid() {
+		return &DecodeError{err: errors.New("cannot unmarshal into nil")}
+	}
 	switch rv.Kind() {
 	case reflect.Interface, reflect.Ptr:
+		if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+			rv = indirect(rv)
+		}
 		rv = rv.Elem()
+		if rv.Kind() == reflect.Interface && rv.NumMethod() == 0 && rv.IsNil() {
+			m := make(map[string]interface{})
+			if err := decodeMapSections(&tree, reflect.ValueOf(&m)); err != nil {
+				return err
+			}
+			rv.Set(reflect.ValueOf(m))
+			return nil
+		}
+		if rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String {
+			return decodeMapSections(&tree, rv.Addr())
+		}
 		if rv.Kind() != reflect.Struct {
 			return &DecodeError{err: fmt.Errorf("cannot unmarshal into value of type %v", rv.Kind())}
 		}
+		if d.opts.CaseInsensitive {
+			tree.foldCase()
+		}
 		// Decode global properties first. By treating rv as the struct to decode
 		// into, we ignore any struct fields that are structs.
-		if err := decodeStruct(tree.global, rv.Addr()); err != nil {
+		if err := d.decodeStruct(tree.global, rv.Addr()); err != nil {
 			return err
 		}
+		if err := d.decodeSections(&tree, "", rv.Addr()); err != nil {
+			return err
+		}
+		if d.opts.DisallowUnknownSections {
+			for _, s := range tree.sections {
+				if !d.seen[s] {
+					d.unknown = append(d.unknown, &UnknownError{Pos: s.pos, Section: s.name})
+				}
+			}
+		}
+	default:
+		return &DecodeError{err: fmt.Errorf("cannot unmarshal into value of type %v", rv.Type())}
+	}
 
-		for i := 0; i < rv.NumField(); i++ {
-			sf := rv.Type().Field(i)
-			sv := rv.Field(i).Addr()
+	slices.SortStableFunc(d.unknown, func(a, b *UnknownError) int {
+		return a.Pos.Offset - b.Pos.Offset
+	})
+	errs := d.missing
+	for _, err := range d.unknown {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
 
-			t := newTag(sf)
-			if t.name == "-" {
-				continue
-			}
+// see records that each of sections is matched by a struct field.
+func (d *decodeState) see(sections ...*section) {
+	if d.seen == nil {
+		d.seen = make(map[*section]bool)
+	}
+	for _, s := range sections {
+		d.seen[s] = true
+	}
+}
+
+// fieldTags returns the tag of each field of the struct type typ, parsed with
+// d.opts. The tags of each type are parsed once, rather than for every section
+// decoded into it.
+func (d *decodeState) fieldTags(typ reflect.Type) []tag {
+	if tags, ok := d.tags[typ]; ok {
+		return tags
+	}
+	if d.tags == nil {
+		d.tags = make(map[reflect.Type][]tag)
+	}
+	tags := make([]tag, typ.NumField())
+	for i := range tags {
+		tags[i] = newTagWithOptions(typ.Field(i), d.opts)
+	}
+	d.tags[typ] = tags
+	return tags
+}
+
+// require records a *MissingError for the section or property key named by t
+// if t has the "required" option.
+func (d *decodeState) require(t tag, section, key string) {
+	if t.required {
+		d.missing = append(d.missing, &MissingError{Section: section, Key: key})
+	}
+}
+
+// decodeSections decodes the sections of tree into the fields of the struct
+// to which rv points that are decoded from a section. Each field is matched to
+// the section found at its name below the section path path; a struct field
+// that is itself decoded from a section matches the section nested below it,
+// such as [server.tls] or [server "tls"] for the field "tls" below "server". A
+// field whose section is missing is left unchanged. It panics if rv is not a
+// reflect.Ptr to a struct.
+func (d *decodeState) decodeSections(tree *parseTree, path string, rv reflect.Value) error {
+	rv = rv.Elem()
+
+	tags := d.fieldTags(rv.Type())
+	for i := 0; i < rv.NumField(); i++ {
+		sf := rv.Type().Field(i)
+		sv := rv.Field(i).Addr()
+
+		t := tags[i]
+		if t.name == "-" {
+			continue
+		}
+		name := t.name
+		if path != "" {
+			name = path + "." + t.name
+		}
 
-			switch sf.Type.Kind() {
-			case reflect.Struct:
-				sections, err := tree.get(t.name)
-				if err != nil {
+		switch {
+		case decodesFromSection(sf.Type):
+			nested := nestsSections(sf.Type)
+			sections, err := tree.get(name)
+			if err != nil {
+				if !nested || !tree.hasSubsections(name) {
+					d.require(t, name, "")
+					// The fields of an absent section take their defaults.
+					if nested && sf.Type.Kind() != reflect.Ptr {
+						if err := decodeDefaults(sv); err != nil {
+							return err
+						}
+					}
+					continue
+				}
+				// A section that holds only nested sections may be omitted.
+				if err := decodeDefaults(indirect(sv)); err != nil {
 					return err
 				}
-				if err := decodeStruct(sections[0], sv); err != nil {
+				if err := d.decodeSections(tree, name, indirect(sv)); err != nil {
 					return err
 				}
-			case reflect.Slice:
-				if sf.Type.Elem().Kind() == reflect.Struct {
-					sections, err := tree.get(t.name)
-					if err != nil {
-						return err
-					}
-					if err := decodeSliceStruct(sections, sv); err != nil {
+				continue
+			}
+			d.see(sections...)
+			if err := d.decodeSection(sections[0], sv); err != nil {
+				return err
+			}
+			if nested {
+				if err := d.decodeSections(tree, name, indirect(sv)); err != nil {
+					return err
+				}
+			}
+		case sf.Type.Kind() == reflect.Slice && decodesFromSection(sf.Type.Elem()):
+			sections, err := tree.get(name)
+			if err != nil {
+				d.require(t, name, "")
+				continue
+			}
+			d.see(sections...)
+			if err := d.decodeSliceStruct(sections, sv); err != nil {
+				return err
+			}
+			if strings.Contains(name, "*") {
+				pattern := sectionPath(name)
+				for i, s := range sections {
+					match, _ := matchSection(pattern, sectionPath(s.name), tree.fold)
+					if err := decodeNameMatch(match, sv.Elem().Index(i).Addr()); err != nil {
 						return err
 					}
 				}
 			}
+		case decodesFromSectionMap(sf.Type):
+			if _, err := tree.get(sectionMapPattern(name)); err != nil {
+				d.require(t, name, "")
+				continue
+			}
+			if err := d.decodeMapStruct(tree, name, sv); err != nil {
+				return err
+			}
 		}
-	default:
-		return &DecodeError{err: fmt.Errorf("cannot unmarshal into value of type %v", rv.Type())}
 	}
 
 	return nil
 }
 
+// decodeSection decodes s into the value to which rv points, calling its
+// UnmarshalINI method if it implements Unmarshaler, or otherwise decoding s
+// into the fields of a struct as decodeStruct does.
+func (d *decodeState) decodeSection(s *section, rv reflect.Value) error {
+	rv = indirect(rv)
+	if u, ok := rv.Interface().(Unmarshaler); ok {
+		return u.UnmarshalINI((*Section)(s))
+	}
+	return d.decodeStruct(s, rv)
+}
+
 // decodeStruct sets the underlying values of the fields of the value to which
-// rv points to the parsed values of s. It panics if rv is not a reflect.Ptr to
-// a struct.
-func decodeStruct(s section, rv reflect.Value) error {
+// rv points to the parsed values of s. Fields decoded from a section are
+// skipped. It panics if rv is not a reflect.Ptr to a struct.
+func (d *decodeState) decodeStruct(s *section, rv reflect.Value) error {
 	rv = rv.Elem()
 
+	known := make([]string, 0, rv.NumField())
+	defer func() {
+		if d.opts.DisallowUnknownKeys {
+			d.unknownKeys(s, known)
+		}
+	}()
+
+	tags := d.fieldTags(rv.Type())
 	for i := 0; i < rv.NumField(); i++ {
 		sf := rv.Type().Field(i)
 		sv := rv.Field(i).Addr()
 
-		t := newTag(sf)
-		if t.name == "-" {
+		t := tags[i]
+		if t.name == "-" || isSectionField(sf.Type) {
 			continue
 		}
+		known = append(known, t.name)
+		if sf.Name != "ININame" && !s.has(t.name) {
+			d.require(t, s.name, t.name)
+			if t.hasDefault {
+				if err := decodeField(defaultSection(t, sf.Type), sf, t, sv); err != nil {
+					return err
+				}
+				continue
+			}
+		}
 
-		var val interface{}
-
-		prop, err := s.get(t.name)
-		if err != nil {
+		if err := decodeField(s, sf, t, sv); err != nil {
 			return err
 		}
-		vals := prop.get("")
+	}
+
+	return nil
+}
+
+// decodeField sets the underlying value of the struct field sf, to which sv
+// points, to the parsed values of the property key named by its tag t in s.
+func decodeField(s *section, sf reflect.StructField, t tag, sv reflect.Value) error {
+	if reflect.PointerTo(baseType(sf.Type)).Implements(keyUnmarshalerType) {
+		if s.has(t.name) {
+			u := indirect(sv).Interface().(KeyUnmarshaler)
+			if err := u.UnmarshalINIKey(&Key{s: s, name: t.name}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 
-		var decoderFunc func(string, reflect.Value) error
+	vals := s.get(t.name, "")
 
-		switch sf.Type.Kind() {
+	decoderFunc := valueDecoder(sf.Type, t)
+	if decoderFunc == nil {
+		// A pointer to a slice or map is left nil if the key is absent, and
+		// allocated otherwise.
+		isPtr := sf.Type.Kind() == reflect.Ptr
+		switch baseType(sf.Type).Kind() {
 		case reflect.Slice:
-			if sf.Type.Elem().Kind() != reflect.Struct {
-				if err := decodeSlice(vals, sv); err != nil {
-					return err
-				}
+			if isPtr && len(vals) == 0 {
+				return nil
 			}
-			continue
+			return decodeSlice(vals, t, indirect(sv))
 		case reflect.Map:
-			if err := decodeMap(*prop, sv); err != nil {
-				return err
+			if isPtr && len(s.subkeys(t.name)) == 0 {
+				return nil
 			}
-			continue
-		case reflect.String:
-			decoderFunc = decodeString
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			decoderFunc = decodeInt
-		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			decoderFunc = decodeUint
-		case reflect.Float32, reflect.Float64:
-			decoderFunc = decodeFloat
-		case reflect.Bool:
-			decoderFunc = decodeBool
-		}
-
-		if sf.Name == "ININame" {
-			vals = append(vals, s.name)
-		}
-		if len(vals) == 0 {
-			continue
+			return decodeMap(s, t, indirect(sv))
 		}
-		val = vals[0]
+		return nil
+	}
 
-		if err := decoderFunc(val.(string), sv); err != nil {
-			return err
+	if sf.Name == "ININame" {
+		vals = append(vals, s.name)
+	}
+	if len(vals) == 0 {
+		return nil
+	}
+
+	return decoderFunc(vals[0], sv)
+}
+
+// defaultSection returns a section holding the default value given by the
+// "default=" option of t, as assignments to the key named by t, for a struct
+// field of type typ. The default of a map is a comma-separated list of
+// subkey=value pairs, and that of a slice a comma-separated list of values;
+// any other default is a single value.
+func defaultSection(t tag, typ reflect.Type) *section {
+	s := newSection("")
+	if valueDecoder(typ, t) != nil {
+		s.add(property{key: t.name, val: t.defaultValue})
+		return s
+	}
+	if t.defaultValue == "" {
+		return s
+	}
+	for _, v := range strings.Split(t.defaultValue, ",") {
+		switch baseType(typ).Kind() {
+		case reflect.Map:
+			k, v, _ := strings.Cut(v, "=")
+			s.add(property{key: t.name, subkey: k, val: v})
+		default:
+			s.add(property{key: t.name, val: v})
+		}
+	}
+	return s
+}
+
+// decodeDefaults sets each field of the struct to which rv points that has a
+// default value to that value, as if it were decoded from a section in which
+// every key is absent, along with the fields of each struct nested within it.
+// It panics if rv is not a reflect.Ptr to a struct.
+func decodeDefaults(rv reflect.Value) error {
+	rv = rv.Elem()
+
+	for i := 0; i < rv.NumField(); i++ {
+		sf := rv.Type().Field(i)
+		sv := rv.Field(i).Addr()
+
+		t := newTag(sf)
+		switch {
+		case t.name == "-":
+		case isSectionField(sf.Type):
+			if sf.Type.Kind() == reflect.Struct && nestsSections(sf.Type) {
+				if err := decodeDefaults(sv); err != nil {
+					return err
+				}
+			}
+		case t.hasDefault:
+			if err := decodeField(defaultSection(t, sf.Type), sf, t, sv); err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
+// unknownKeys records an *UnknownError for the first assignment of each
+// property key of s that is not in known.
+func (d *decodeState) unknownKeys(s *section, known []string) {
+	for _, p := range s.props {
+		if slices.ContainsFunc(known, func(k string) bool { return equalName(k, p.key, s.fold) }) {
+			continue
+		}
+		known = append(known, p.key)
+		d.unknown = append(d.unknown, &UnknownError{Pos: p.pos, Section: s.name, Key: p.key})
+	}
+}
+
 // decodeSliceStruct sets the underlying values of the fields of the elements to
 // which rv points to the parsed values of s. It pancis if rv is not a
 // reflect.Ptr to a slice of structs.
-func decodeSliceStruct(s []section, rv reflect.Value) error {
+func (d *decodeState) decodeSliceStruct(s []*section, rv reflect.Value) error {
 	rv = rv.Elem()
 
 	vv := reflect.MakeSlice(rv.Type(), len(s), cap(s))
 
 	for i := 0; i < vv.Len(); i++ {
 		sv := vv.Index(i).Addr()
-		if err := decodeStruct(s[i], sv); err != nil {
+		if err := d.decodeSection(s[i], sv); err != nil {
+			return err
+		}
+	}
+
+	rv.Set(vv)
+
+	return nil
+}
+
+// sectionMapPattern returns the pattern matching the sections of a map of
+// structs named name: name itself if it is a pattern, or otherwise a pattern
+// matching every section directly below it.
+func sectionMapPattern(name string) string {
+	pattern := sectionPath(name)
+	if !strings.Contains(pattern, "*") {
+		pattern += ".*"
+	}
+	return pattern
+}
+
+// decodeMapStruct sets the underlying keys and values of the elements of the
+// map to which rv points to the parsed values of the sections found directly
+// below the section path name, keyed by the remainder of their path, such as
+// "origin" for the section [remote "origin"] below "remote". If name is a
+// pattern, the sections matching it are keyed by the part of their path
+// matched by the first asterisk instead. If a key occurs more than once, the
+// first section is decoded. It panics if rv is not a reflect.Ptr to a map with
+// string keys.
+func (d *decodeState) decodeMapStruct(tree *parseTree, name string, rv reflect.Value) error {
+	rv = rv.Elem()
+
+	nested := nestsSections(rv.Type().Elem())
+	pattern := sectionMapPattern(name)
+
+	keys := make([]string, 0)
+	quoted := make(map[string]bool)
+	sections := make(map[string][]*section)
+	for _, s := range tree.sections {
+		k, ok := matchSection(pattern, sectionPath(s.name), tree.fold)
+		// A quoted subsection name is a single key, even if it contains a dot,
+		// such as "v1.2" for [branch "v1.2"]. Sections nested below it, such
+		// as [branch "v1.2".tls], are decoded into its element.
+		if base, sub, rest, isQuoted := subsection(s.name); isQuoted && pattern == sectionPath(name)+".*" {
+			if !equalName(sectionPath(base), sectionPath(name), tree.fold) || rest != "" {
+				continue
+			}
+			k, ok = sub, true
+			quoted[k] = true
+		}
+		if !ok {
+			continue
+		}
+		if _, ok := sections[k]; !ok {
+			keys = append(keys, k)
+		}
+		sections[k] = append(sections[k], s)
+	}
+
+	vv := reflect.MakeMap(rv.Type())
+
+	for _, k := range keys {
+		// Dotted sections nested below an element, such as [remote.origin.tls],
+		// are decoded into that element rather than keyed by their own path.
+		if nested && !quoted[k] && slices.ContainsFunc(keys, func(p string) bool {
+			return strings.HasPrefix(k, p+".")
+		}) {
+			continue
+		}
+
+		d.see(sections[k]...)
+		mv := reflect.New(rv.Type().Elem())
+		if err := d.decodeSection(sections[k][0], mv); err != nil {
+			return err
+		}
+		if err := decodeNameMatch(k, mv); err != nil {
 			return err
 		}
+		if nested {
+			if err := d.decodeSections(tree, sectionPath(sections[k][0].name), indirect(mv)); err != nil {
+				return err
+			}
+		}
+		vv.SetMapIndex(reflect.ValueOf(k).Convert(rv.Type().Key()), mv.Elem())
 	}
 
 	rv.Set(vv)
@@ -223,26 +760,215 @@ func decodeSliceStruct(s []section, rv reflect.Value) error {
 	return nil
 }
 
+// decodeMapSections sets the underlying keys and values of the map to which rv
+// points to the sections of tree, keyed by section name. The global section is
+// keyed by the empty string, if it has any properties. Each value is decoded
+// by decodeSectionValue, in the order the sections appear. A value already in
+// the map is replaced, as encoding/json replaces it, rather than merged with
+// the sections of its name. It panics if rv is not a reflect.Ptr to a map with
+// string keys.
+func decodeMapSections(tree *parseTree, rv reflect.Value) error {
+	rv = rv.Elem()
+	if rv.IsNil() {
+		rv.Set(reflect.MakeMap(rv.Type()))
+	}
+
+	sections := tree.sections
+	if len(tree.global.props) > 0 {
+		sections = append([]*section{tree.global}, sections...)
+	}
+
+	decoded := make(map[string]bool)
+	for _, s := range sections {
+		k := reflect.ValueOf(s.name).Convert(rv.Type().Key())
+		mv := reflect.New(rv.Type().Elem())
+		if v := rv.MapIndex(k); v.IsValid() && decoded[s.name] {
+			mv.Elem().Set(v)
+		}
+		decoded[s.name] = true
+		if err := decodeSectionValue(s, mv); err != nil {
+			return err
+		}
+		rv.SetMapIndex(k, mv.Elem())
+	}
+
+	return nil
+}
+
+// decodeSectionValue decodes s into the value to which rv points, which holds
+// the value decoded from any earlier section of the same name. An interface
+// value is set to the map[string]interface{} returned by sectionValues, or to
+// a []interface{} of such maps if the section is repeated. A slice has a map
+// decoded from s appended to it, and a map has the properties of s added to it
+// by decodePropertyMap. It panics if rv is not a reflect.Ptr.
+func decodeSectionValue(s *section, rv reflect.Value) error {
+	v := rv.Elem()
+
+	switch {
+	case v.Kind() == reflect.Interface && v.NumMethod() == 0:
+		m := sectionValues(s)
+		switch prev := v.Interface().(type) {
+		case nil:
+			v.Set(reflect.ValueOf(m))
+		case []interface{}:
+			v.Set(reflect.ValueOf(append(prev, m)))
+		default:
+			v.Set(reflect.ValueOf([]interface{}{prev, m}))
+		}
+	case v.Kind() == reflect.Slice:
+		ev := reflect.New(v.Type().Elem())
+		if err := decodePropertyMap(s, ev); err != nil {
+			return err
+		}
+		v.Set(reflect.Append(v, ev.Elem()))
+	default:
+		return decodePropertyMap(s, rv)
+	}
+
+	return nil
+}
+
+// decodePropertyMap adds the properties of s to the map to which rv points,
+// keyed by property key. A property assigned to a subkey is keyed by the key
+// and the subkey in brackets, as in "shell[unix]". A value that a property
+// value is decoded into holds the first value assigned to its key; a slice
+// holds every value, in the order they appear. An interface value is set as
+// sectionValues sets it. Keys already present in the map, decoded from an
+// earlier section of the same name, are kept. It panics if rv is not a
+// reflect.Ptr.
+func decodePropertyMap(s *section, rv reflect.Value) error {
+	rv = rv.Elem()
+	if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+		return &UnmarshalTypeError{val: "section", typ: rv.Type()}
+	}
+	if rv.IsNil() {
+		rv.Set(reflect.MakeMap(rv.Type()))
+	}
+
+	typ := rv.Type().Elem()
+	if typ.Kind() == reflect.Interface && typ.NumMethod() == 0 {
+		for k, v := range sectionValues(s) {
+			mk := reflect.ValueOf(k).Convert(rv.Type().Key())
+			if !rv.MapIndex(mk).IsValid() {
+				rv.SetMapIndex(mk, reflect.ValueOf(v))
+			}
+		}
+		return nil
+	}
+
+	decoderFunc := valueDecoder(typ, tag{})
+	if decoderFunc == nil && typ.Kind() == reflect.Slice {
+		decoderFunc = valueDecoder(typ.Elem(), tag{})
+	}
+	if decoderFunc == nil {
+		return &UnmarshalTypeError{val: "property value", typ: typ}
+	}
+
+	for _, p := range s.props {
+		k := p.key
+		if p.subkey != "" {
+			k += "[" + p.subkey + "]"
+		}
+		mk := reflect.ValueOf(k).Convert(rv.Type().Key())
+
+		if typ.Kind() != reflect.Slice {
+			if rv.MapIndex(mk).IsValid() {
+				continue
+			}
+			mv := reflect.New(typ)
+			if err := decoderFunc(p.val, mv); err != nil {
+				return err
+			}
+			rv.SetMapIndex(mk, mv.Elem())
+			continue
+		}
+
+		ev := reflect.New(typ.Elem())
+		if err := decoderFunc(p.val, ev); err != nil {
+			return err
+		}
+		mv := rv.MapIndex(mk)
+		if !mv.IsValid() {
+			mv = reflect.Zero(typ)
+		}
+		rv.SetMapIndex(mk, reflect.Append(mv, ev.Elem()))
+	}
+
+	return nil
+}
+
+// sectionValues returns the properties of s as a map[string]interface{},
+// keyed by property key. A key assigned a single value maps to the value as a
+// string, and a key assigned more than one maps to a []interface{} of each of
+// them. A key with subkeys maps to a nested map[string]interface{} of the
+// values of each subkey, in which any values assigned to the key itself are
+// keyed by the empty string.
+func sectionValues(s *section) map[string]interface{} {
+	m := make(map[string]interface{})
+	for _, k := range s.keys() {
+		vals := s.get(k, "")
+		subkeys := s.subkeys(k)
+		if len(subkeys) == 0 {
+			m[k] = propertyValues(vals)
+			continue
+		}
+		sm := make(map[string]interface{})
+		if len(vals) > 0 {
+			sm[""] = propertyValues(vals)
+		}
+		for _, sk := range subkeys {
+			sm[sk] = propertyValues(s.get(k, sk))
+		}
+		m[k] = sm
+	}
+	return m
+}
+
+// propertyValues returns vals as a single string if it holds one value, or as
+// a []interface{} otherwise.
+func propertyValues(vals []string) interface{} {
+	if len(vals) == 1 {
+		return vals[0]
+	}
+	v := make([]interface{}, len(vals))
+	for i, val := range vals {
+		v[i] = val
+	}
+	return v
+}
+
+// decodeNameMatch sets the field named "ININameMatch" of the struct to which
+// rv points, if it has one, to match, the part of the section name matched by
+// a pattern or the subsection name of a map element.
+func decodeNameMatch(match string, rv reflect.Value) error {
+	rv = indirect(rv).Elem()
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	sf, ok := rv.Type().FieldByName("ININameMatch")
+	if !ok {
+		return nil
+	}
+	decoderFunc := valueDecoder(sf.Type, newTag(sf))
+	if decoderFunc == nil {
+		return &UnmarshalTypeError{
+			val: "string",
+			typ: sf.Type,
+			str: rv.Type().Name(),
+			fld: sf.Name,
+		}
+	}
+	return decoderFunc(match, rv.FieldByIndex(sf.Index).Addr())
+}
+
 // decodeSlice sets the underlying values of the elements of the value to which
 // rv points to the parsed values of s. It panics if rv is not a reflect.Ptr to
 // a slice.
-func decodeSlice(s []string, rv reflect.Value) error {
+func decodeSlice(s []string, t tag, rv reflect.Value) error {
 	rv = rv.Elem()
 
-	var decoderFunc func(string, reflect.Value) error
-
-	switch rv.Type().Elem().Kind() {
-	case reflect.String:
-		decoderFunc = decodeString
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		decoderFunc = decodeInt
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		decoderFunc = decodeUint
-	case reflect.Float32, reflect.Float64:
-		decoderFunc = decodeFloat
-	case reflect.Bool:
-		decoderFunc = decodeBool
-	default:
+	decoderFunc := valueDecoder(rv.Type().Elem(), t)
+	if decoderFunc == nil {
 		return &UnmarshalTypeError{
 			val: reflect.ValueOf(s).String(),
 			typ: rv.Type(),
@@ -264,50 +990,55 @@ func decodeSlice(s []string, rv reflect.Value) error {
 }
 
 // decodeMap sets the underlying keys and values of the elements of the value to
-// which rv points to the parsed values of p. It panics if rv is not a
-// reflect.Ptr to a map[string]interface{}.
-func decodeMap(p property, rv reflect.Value) error {
+// which rv points to the parsed values of the subkeys of the key named by t in
+// s. It panics if rv is not a reflect.Ptr to a map[string]interface{}.
+func decodeMap(s *section, t tag, rv reflect.Value) error {
 	rv = rv.Elem()
+	typ := rv.Type().Elem()
 
-	vv := reflect.MakeMap(rv.Type())
-
-	for k, v := range p.vals {
-		mv := reflect.New(rv.Type().Elem())
+	// A map of slices has each value of a subkey appended to its slice, and
+	// any other map the first value of each subkey.
+	decoderFunc := valueDecoder(typ, t)
+	appends := decoderFunc == nil && typ.Kind() == reflect.Slice
+	if appends {
+		decoderFunc = valueDecoder(typ.Elem(), t)
+	}
 
-		var decoderFunc func(string, reflect.Value) error
+	vv := reflect.MakeMap(rv.Type())
 
-		switch rv.Type().Elem().Kind() {
-		case reflect.Slice:
-			if err := decodeSlice(v, mv); err != nil {
-				return err
-			}
-			vv.SetMapIndex(reflect.ValueOf(k), mv.Elem())
+	for _, p := range s.props {
+		if p.subkey == "" || !equalName(p.key, t.name, s.fold) {
 			continue
-		case reflect.String:
-			decoderFunc = decodeString
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			decoderFunc = decodeInt
-		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			decoderFunc = decodeUint
-		case reflect.Float32, reflect.Float64:
-			decoderFunc = decodeFloat
-		case reflect.Bool:
-			decoderFunc = decodeBool
-		default:
+		}
+		if decoderFunc == nil {
 			return &UnmarshalTypeError{
-				val: reflect.ValueOf(p).String(),
+				val: reflect.ValueOf(p.val).String(),
 				typ: rv.Type(),
 			}
 		}
 
-		if len(v) == 0 {
+		k := reflect.ValueOf(p.subkey)
+		mv := vv.MapIndex(k)
+		if !appends {
+			if mv.IsValid() {
+				continue
+			}
+			ev := reflect.New(typ)
+			if err := decoderFunc(p.val, ev); err != nil {
+				return err
+			}
+			vv.SetMapIndex(k, ev.Elem())
 			continue
 		}
 
-		if err := decoderFunc(v[0], mv); err != nil {
+		ev := reflect.New(typ.Elem())
+		if err := decoderFunc(p.val, ev); err != nil {
 			return err
 		}
-		vv.SetMapIndex(reflect.ValueOf(k), mv.Elem())
+		if !mv.IsValid() {
+			mv = reflect.Zero(typ)
+		}
+		vv.SetMapIndex(k, reflect.Append(mv, ev.Elem()))
 	}
 
 	rv.Set(vv)
@@ -315,6 +1046,157 @@ func decodeMap(p property, rv reflect.Value) error {
 	return nil
 }
 
+var (
+	unmarshalerType     = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	keyUnmarshalerType  = reflect.TypeOf((*KeyUnmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	durationType        = reflect.TypeOf(time.Duration(0))
+	timeType            = reflect.TypeOf(time.Time{})
+)
+
+// indirect allocates a new value for each nil pointer in the chain of
+// pointers starting at the value to which rv points, and returns a pointer to
+// the first value in the chain that is not itself a pointer.
+func indirect(rv reflect.Value) reflect.Value {
+	for rv.Elem().Kind() == reflect.Ptr {
+		if rv.Elem().IsNil() {
+			rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+	return rv
+}
+
+// baseType returns the type that t points to, following any number of
+// pointers, or t itself if t is not a pointer type.
+func baseType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// decodesFromSection reports whether values of type t are decoded from a
+// section, rather than from a property value. Pointers are decoded as the type
+// they point to.
+func decodesFromSection(t reflect.Type) bool {
+	t = baseType(t)
+	pt := reflect.PointerTo(t)
+	if pt.Implements(unmarshalerType) {
+		return true
+	}
+	return t.Kind() == reflect.Struct && !pt.Implements(keyUnmarshalerType) && !pt.Implements(textUnmarshalerType)
+}
+
+// isSectionField reports whether a struct field of type t is decoded from one
+// or more sections, rather than from the properties of a section.
+func isSectionField(t reflect.Type) bool {
+	return decodesFromSection(t) || t.Kind() == reflect.Slice && decodesFromSection(t.Elem()) || decodesFromSectionMap(t)
+}
+
+// decodesFromSectionMap reports whether values of type t are maps decoded
+// from the sections found below a section path, keyed by subsection name.
+func decodesFromSectionMap(t reflect.Type) bool {
+	return t.Kind() == reflect.Map && t.Key().Kind() == reflect.String && decodesFromSection(t.Elem()) && !decodesFromSection(t)
+}
+
+// nestsSections reports whether values of type t, which is decoded from a
+// section, may have fields decoded from sections nested below it. Types that
+// implement Unmarshaler decode their section themselves.
+func nestsSections(t reflect.Type) bool {
+	t = baseType(t)
+	return t.Kind() == reflect.Struct && !reflect.PointerTo(t).Implements(unmarshalerType)
+}
+
+// valueDecoder returns the function that decodes a single property value into
+// a value of type typ, or nil if typ is not decoded from a single property
+// value. The options of the struct field tag t apply to durations and times.
+// Types that implement encoding.TextUnmarshaler, with either a pointer or a
+// value receiver, are decoded by their UnmarshalText method. Pointer types are
+// decoded as the type they point to, allocating each nil pointer on the way.
+func valueDecoder(typ reflect.Type, t tag) func(string, reflect.Value) error {
+	if typ.Kind() == reflect.Ptr {
+		decoderFunc := valueDecoder(baseType(typ), t)
+		if decoderFunc == nil {
+			return nil
+		}
+		return func(s string, rv reflect.Value) error {
+			return decoderFunc(s, indirect(rv))
+		}
+	}
+	switch typ {
+	case durationType:
+		return func(s string, rv reflect.Value) error {
+			return decodeDuration(s, rv, t.seconds)
+		}
+	case timeType:
+		return func(s string, rv reflect.Value) error {
+			return decodeTime(s, rv, t.layout)
+		}
+	}
+	if reflect.PointerTo(typ).Implements(textUnmarshalerType) {
+		return decodeText
+	}
+	switch typ.Kind() {
+	case reflect.String:
+		return decodeString
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return decodeInt
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return decodeUint
+	case reflect.Float32, reflect.Float64:
+		return decodeFloat
+	case reflect.Bool:
+		return decodeBool
+	}
+	return nil
+}
+
+// decodeText sets the underlying value of the value to which rv points by
+// calling its UnmarshalText method with s. It panics if rv does not implement
+// encoding.TextUnmarshaler.
+func decodeText(s string, rv reflect.Value) error {
+	if err := rv.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+		return &DecodeError{err}
+	}
+	return nil
+}
+
+// decodeDuration sets the underlying value of the value to which rv points to
+// the duration parsed from s by time.ParseDuration. If seconds is true, s may
+// instead be a plain number of seconds. It panics if rv is not a reflect.Ptr to
+// a time.Duration.
+func decodeDuration(s string, rv reflect.Value, seconds bool) error {
+	d, err := time.ParseDuration(s)
+	if err != nil && seconds {
+		if n, ferr := strconv.ParseFloat(s, 64); ferr == nil {
+			d, err = time.Duration(n*float64(time.Second)), nil
+		}
+	}
+	if err != nil {
+		return &DecodeError{err}
+	}
+
+	rv.Elem().SetInt(int64(d))
+	return nil
+}
+
+// decodeTime sets the underlying value of the value to which rv points to the
+// time parsed from s according to layout, or time.RFC3339 if layout is empty.
+// It panics if rv is not a reflect.Ptr to a time.Time.
+func decodeTime(s string, rv reflect.Value, layout string) error {
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	tm, err := time.Parse(layout, s)
+	if err != nil {
+		return &DecodeError{err}
+	}
+
+	rv.Elem().Set(reflect.ValueOf(tm))
+	return nil
+}
+
 // decodeString sets the underlying value of the value to which rv points to
 // the parsed value of s. It panics if rv is not a reflect.Ptr to a string.
 func decodeString(s string, rv reflect.Value) error {
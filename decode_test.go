@@ -3,8 +3,17 @@ package ini
 import (
 	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/google/go-cmp/cmp/cmpopts"
 
@@ -232,10 +241,188 @@ func TestDecodeFloat(t *testing.T) {
 	}
 }
 
+func (p *point) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "(%d,%d)", &p.x, &p.y)
+	return err
+}
+
+// lower implements encoding.TextUnmarshaler with a value receiver, storing the
+// lower case form of the text in the string to which it points.
+type lower struct {
+	s *string
+}
+
+func (l lower) UnmarshalText(text []byte) error {
+	*l.s = strings.ToLower(string(text))
+	return nil
+}
+
+func (e *environment) UnmarshalINI(s *Section) error {
+	*e = make(environment)
+	for _, k := range s.Keys() {
+		(*e)[k.Name()] = k.Values()
+	}
+	return nil
+}
+
+func (v *version) UnmarshalINIKey(k *Key) error {
+	var err error
+	if v.major, err = strconv.Atoi(k.SubkeyValue("major")); err != nil {
+		return err
+	}
+	v.minor, err = strconv.Atoi(k.SubkeyValue("minor"))
+	return err
+}
+
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		want        point
+		shouldError bool
+	}{
+		{
+			description: "valid",
+			input:       "(1,3)",
+			want:        point{1, 3},
+		},
+		{
+			description: "invalid",
+			input:       "1,3",
+			shouldError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var got point
+			err := decodeText(test.input, reflect.ValueOf(&got))
+
+			if test.shouldError {
+				var decodeErr *DecodeError
+				if !errors.As(err, &decodeErr) {
+					t.Fatalf("decodeText(%v) returned %v, want *DecodeError", test.input, err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("decodeText(%v) returned %v, want nil", test.input, err)
+				}
+				if !cmp.Equal(got, test.want, cmp.AllowUnexported(point{})) {
+					t.Errorf("decodeText(%v) = %v, want %v", test.input, got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeDuration(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		seconds     bool
+		want        time.Duration
+		shouldError bool
+		wantError   error
+	}{
+		{
+			description: "valid",
+			input:       "1m30s",
+			want:        90 * time.Second,
+		},
+		{
+			description: "plain seconds",
+			input:       "1.5",
+			seconds:     true,
+			want:        1500 * time.Millisecond,
+		},
+		{
+			description: "plain seconds not permitted",
+			input:       "30",
+			shouldError: true,
+			wantError:   &DecodeError{errors.New(`time: missing unit in duration "30"`)},
+		},
+		{
+			description: "invalid parse syntax",
+			input:       "thirty seconds",
+			seconds:     true,
+			shouldError: true,
+			wantError:   &DecodeError{errors.New(`time: invalid duration "thirty seconds"`)},
+		},
+	}
+
+	for _, test := range tests {
+		var got time.Duration
+		rv := reflect.ValueOf(&got)
+
+		err := decodeDuration(test.input, rv, test.seconds)
+
+		if test.shouldError {
+			if err == nil || err.Error() != test.wantError.Error() {
+				t.Fatalf("decodeDuration(%v) returned %v, want %v", test.input, err, test.wantError)
+			}
+		} else {
+			if err != nil {
+				t.Fatalf("decodeDuration(%v) returned %v, want %v", test.input, err, test.wantError)
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("decodeDuration(%v) = %v, want %v", test.input, got, test.want)
+			}
+		}
+	}
+}
+
+func TestDecodeTime(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		layout      string
+		want        time.Time
+		shouldError bool
+	}{
+		{
+			description: "default layout",
+			input:       "2021-03-04T05:06:07+02:00",
+			want:        time.Date(2021, 3, 4, 5, 6, 7, 0, time.FixedZone("", 2*60*60)),
+		},
+		{
+			description: "layout",
+			input:       "2021-03-04",
+			layout:      "2006-01-02",
+			want:        time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			description: "layout mismatch",
+			input:       "2021-03-04T05:06:07Z",
+			layout:      "2006-01-02",
+			shouldError: true,
+		},
+	}
+
+	for _, test := range tests {
+		var got time.Time
+		rv := reflect.ValueOf(&got)
+
+		err := decodeTime(test.input, rv, test.layout)
+
+		if test.shouldError {
+			if err == nil {
+				t.Fatalf("decodeTime(%v) returned nil, want error", test.input)
+			}
+		} else {
+			if err != nil {
+				t.Fatalf("decodeTime(%v) returned %v, want nil", test.input, err)
+			}
+			if !got.Equal(test.want) {
+				t.Errorf("decodeTime(%v) = %v, want %v", test.input, got, test.want)
+			}
+		}
+	}
+}
+
 func TestDecodeStruct(t *testing.T) {
 	tests := []struct {
 		description string
-		input       section
+		input       *section
 		want        interface{}
 		shouldError bool
 		wantError   error
@@ -243,7 +430,7 @@ func TestDecodeStruct(t *testing.T) {
 	}{
 		{
 			description: "decodeString",
-			input:       section{"section", map[string]property{"property": {"property", map[string][]string{"": {"value"}}}}},
+			input:       &section{name: "section", props: []property{{key: "property", val: "value"}}},
 			want: &struct {
 				Property string `ini:"property"`
 			}{"value"},
@@ -255,7 +442,7 @@ func TestDecodeStruct(t *testing.T) {
 		},
 		{
 			description: "decodeInt",
-			input:       section{"section", map[string]property{"property": {"property", map[string][]string{"": {"0"}}}}},
+			input:       &section{name: "section", props: []property{{key: "property", val: "0"}}},
 			want: &struct {
 				Property int `ini:"property"`
 			}{0},
@@ -267,7 +454,7 @@ func TestDecodeStruct(t *testing.T) {
 		},
 		{
 			description: "decodeUint",
-			input:       section{"section", map[string]property{"property": {"property", map[string][]string{"": {"0"}}}}},
+			input:       &section{name: "section", props: []property{{key: "property", val: "0"}}},
 			want: &struct {
 				Property uint `ini:"property"`
 			}{0},
@@ -279,7 +466,7 @@ func TestDecodeStruct(t *testing.T) {
 		},
 		{
 			description: "decodeFloat",
-			input:       section{"section", map[string]property{"property": {"property", map[string][]string{"": {"0.0"}}}}},
+			input:       &section{name: "section", props: []property{{key: "property", val: "0.0"}}},
 			want: &struct {
 				Property float64 `ini:"property"`
 			}{0.0},
@@ -291,7 +478,7 @@ func TestDecodeStruct(t *testing.T) {
 		},
 		{
 			description: "decodeBool",
-			input:       section{"section", map[string]property{"property": {"property", map[string][]string{"": {"1"}}}}},
+			input:       &section{name: "section", props: []property{{key: "property", val: "1"}}},
 			want: &struct {
 				Property bool `ini:"property"`
 			}{true},
@@ -303,7 +490,7 @@ func TestDecodeStruct(t *testing.T) {
 		},
 		{
 			description: "skip property",
-			input:       section{"section", map[string]property{"property": {"property", map[string][]string{"": {"0"}}}}},
+			input:       &section{name: "section", props: []property{{key: "property", val: "0"}}},
 			want: &struct {
 				Property int `ini:"-"`
 			}{0},
@@ -319,7 +506,7 @@ func TestDecodeStruct(t *testing.T) {
 		t.Run(test.description, func(t *testing.T) {
 			got := test.init()
 
-			err := decodeStruct(test.input, reflect.ValueOf(got))
+			err := new(decodeState).decodeStruct(test.input, reflect.ValueOf(got))
 			if test.shouldError {
 				if !cmp.Equal(err, test.wantError, cmpopts.IgnoreUnexported(DecodeError{}, UnmarshalTypeError{})) {
 					t.Fatalf("decodeStruct(%v) returned %v, want %v", test.input, err, test.wantError)
@@ -340,34 +527,24 @@ func TestDecodeStruct(t *testing.T) {
 func TestDecodeSliceStruct(t *testing.T) {
 	tests := []struct {
 		description string
-		input       []section
+		input       []*section
 		want        interface{}
 		shouldError bool
 		wantError   error
 		init        func() interface{}
 	}{
 		{
-			input: []section{
+			input: []*section{
 				{
 					name: "section",
-					props: map[string]property{
-						"property": {
-							key: "property",
-							vals: map[string][]string{
-								"": {"value0"},
-							},
-						},
+					props: []property{
+						{key: "property", val: "value0"},
 					},
 				},
 				{
 					name: "section",
-					props: map[string]property{
-						"property": {
-							key: "property",
-							vals: map[string][]string{
-								"": {"value1"},
-							},
-						},
+					props: []property{
+						{key: "property", val: "value1"},
 					},
 				},
 			},
@@ -387,13 +564,36 @@ func TestDecodeSliceStruct(t *testing.T) {
 				}{}
 			},
 		},
+		{
+			description: "pointer elements",
+			input: []*section{
+				{
+					name: "section",
+					props: []property{
+						{key: "property", val: "value0"},
+					},
+				},
+			},
+			want: &[]*struct {
+				Property string `ini:"property"`
+			}{
+				{
+					Property: "value0",
+				},
+			},
+			init: func() interface{} {
+				return &[]*struct {
+					Property string `ini:"property"`
+				}{}
+			},
+		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.description, func(t *testing.T) {
 			got := test.init()
 
-			err := decodeSliceStruct(test.input, reflect.ValueOf(got))
+			err := new(decodeState).decodeSliceStruct(test.input, reflect.ValueOf(got))
 			if test.shouldError {
 				if !cmp.Equal(err, test.wantError, cmpopts.IgnoreUnexported(DecodeError{}, UnmarshalTypeError{})) {
 					t.Fatalf("decodeSliceStruct(%v) returned %v, want %v", test.input, err, test.wantError)
@@ -489,7 +689,7 @@ func TestDecodeSlice(t *testing.T) {
 		t.Run(test.description, func(t *testing.T) {
 			got := test.init()
 
-			err := decodeSlice(test.input, reflect.ValueOf(got))
+			err := decodeSlice(test.input, tag{}, reflect.ValueOf(got))
 			if test.shouldError {
 				if !cmp.Equal(err, test.wantError, cmpopts.IgnoreUnexported(DecodeError{}, UnmarshalTypeError{})) {
 					t.Fatalf("decodeSlice(%v) returned %v, want %v", test.input, err, test.wantError)
@@ -510,7 +710,7 @@ func TestDecodeSlice(t *testing.T) {
 func TestDecodeMap(t *testing.T) {
 	tests := []struct {
 		description string
-		input       property
+		input       *section
 		want        interface{}
 		shouldError bool
 		wantError   error
@@ -518,7 +718,7 @@ func TestDecodeMap(t *testing.T) {
 	}{
 		{
 			description: "map[string]string",
-			input:       property{key: "p", vals: map[string][]string{"k1": {"v1"}, "k2": {"v2"}}},
+			input:       &section{props: []property{{key: "p", subkey: "k1", val: "v1"}, {key: "p", subkey: "k2", val: "v2"}}},
 			want:        &map[string]string{"k1": "v1", "k2": "v2"},
 			init: func() interface{} {
 				return &map[string]string{}
@@ -526,7 +726,7 @@ func TestDecodeMap(t *testing.T) {
 		},
 		{
 			description: "map[string]int",
-			input:       property{key: "p", vals: map[string][]string{"k1": {"0"}, "k2": {"1"}}},
+			input:       &section{props: []property{{key: "p", subkey: "k1", val: "0"}, {key: "p", subkey: "k2", val: "1"}}},
 			want:        &map[string]int{"k1": 0, "k2": 1},
 			init: func() interface{} {
 				return &map[string]int{}
@@ -534,7 +734,7 @@ func TestDecodeMap(t *testing.T) {
 		},
 		{
 			description: "map[string]uint",
-			input:       property{key: "p", vals: map[string][]string{"k1": {"0"}, "k2": {"1"}}},
+			input:       &section{props: []property{{key: "p", subkey: "k1", val: "0"}, {key: "p", subkey: "k2", val: "1"}}},
 			want:        &map[string]uint{"k1": 0, "k2": 1},
 			init: func() interface{} {
 				return &map[string]uint{}
@@ -542,7 +742,7 @@ func TestDecodeMap(t *testing.T) {
 		},
 		{
 			description: "map[string]float64",
-			input:       property{key: "p", vals: map[string][]string{"k1": {"0.0"}, "k2": {"1.0"}}},
+			input:       &section{props: []property{{key: "p", subkey: "k1", val: "0.0"}, {key: "p", subkey: "k2", val: "1.0"}}},
 			want:        &map[string]float64{"k1": 0.0, "k2": 1.0},
 			init: func() interface{} {
 				return &map[string]float64{}
@@ -550,7 +750,7 @@ func TestDecodeMap(t *testing.T) {
 		},
 		{
 			description: "map[string]bool",
-			input:       property{key: "p", vals: map[string][]string{"k1": {"0"}, "k2": {"1"}}},
+			input:       &section{props: []property{{key: "p", subkey: "k1", val: "0"}, {key: "p", subkey: "k2", val: "1"}}},
 			want:        &map[string]bool{"k1": false, "k2": true},
 			init: func() interface{} {
 				return &map[string]bool{}
@@ -558,7 +758,7 @@ func TestDecodeMap(t *testing.T) {
 		},
 		{
 			description: "map[string][]string",
-			input:       property{key: "p", vals: map[string][]string{"k1": {"v0", "v1"}}},
+			input:       &section{props: []property{{key: "p", subkey: "k1", val: "v0"}, {key: "p", subkey: "k1", val: "v1"}}},
 			want:        &map[string][]string{"k1": {"v0", "v1"}},
 			init: func() interface{} {
 				return &map[string][]string{}
@@ -566,7 +766,7 @@ func TestDecodeMap(t *testing.T) {
 		},
 		{
 			description: "map[string]struct{}",
-			input:       property{key: "p", vals: map[string][]string{"k1": {"0"}, "k2": {"1"}}},
+			input:       &section{props: []property{{key: "p", subkey: "k1", val: "0"}, {key: "p", subkey: "k2", val: "1"}}},
 			want:        &map[string]struct{}{},
 			shouldError: true,
 			wantError:   &UnmarshalTypeError{val: reflect.ValueOf(property{}).String(), typ: reflect.TypeOf(&map[string]struct{}{})},
@@ -580,7 +780,7 @@ func TestDecodeMap(t *testing.T) {
 		t.Run(test.description, func(t *testing.T) {
 			got := test.init()
 
-			err := decodeMap(test.input, reflect.ValueOf(got))
+			err := decodeMap(test.input, tag{name: "p"}, reflect.ValueOf(got))
 			if test.shouldError {
 				if !cmp.Equal(err, test.wantError, cmpopts.IgnoreUnexported(DecodeError{}, UnmarshalTypeError{})) {
 					t.Fatalf("decodeMap(%v) returned %v, want %v", test.input, err, test.wantError)
@@ -610,47 +810,25 @@ func TestDecode(t *testing.T) {
 		{
 			description: "decode top-level struct",
 			input: parseTree{
-				global: section{
+				global: &section{
 					name: "",
-					props: map[string]property{
-						"property": {
-							key: "property",
-							vals: map[string][]string{
-								"": {"value"},
-							},
-						},
-						"map": {
-							key: "map",
-							vals: map[string][]string{
-								"k1": {"v1"},
-								"k2": {"v2"},
-							},
-						},
+					props: []property{
+						{key: "property", val: "value"},
+						{key: "map", subkey: "k1", val: "v1"},
+						{key: "map", subkey: "k2", val: "v2"},
 					},
 				},
-				sections: map[string][]section{
-					"section1": {
-						{
-							name: "section1",
-							props: map[string]property{
-								"key1": {
-									key: "key1",
-									vals: map[string][]string{
-										"": {"value1"},
-									},
-								},
-							},
+				sections: []*section{
+					{
+						name: "section1",
+						props: []property{
+							{key: "key1", val: "value1"},
 						},
-						{
-							name: "section1",
-							props: map[string]property{
-								"key1": {
-									key: "key1",
-									vals: map[string][]string{
-										"": {"value2"},
-									},
-								},
-							},
+					},
+					{
+						name: "section1",
+						props: []property{
+							{key: "key1", val: "value2"},
 						},
 					},
 				},
@@ -685,7 +863,7 @@ func TestDecode(t *testing.T) {
 			},
 		},
 		{
-			description: "decode top-level map",
+			description: "decode top-level map value",
 			input:       parseTree{},
 			want:        map[string]interface{}{},
 			init: func() interface{} {
@@ -700,7 +878,7 @@ func TestDecode(t *testing.T) {
 		t.Run(test.description, func(t *testing.T) {
 			got := test.init()
 
-			err := decode(test.input, reflect.ValueOf(got))
+			err := new(decodeState).decode(test.input, reflect.ValueOf(got))
 			if test.shouldError {
 				if !cmp.Equal(err, test.wantError, cmpopts.IgnoreUnexported(DecodeError{}, UnmarshalTypeError{})) {
 					t.Fatalf("decode(%+v) returned %v, want %v", test.input, err, test.wantError)
@@ -846,11 +1024,1388 @@ func TestUnmarshalWildcard(t *testing.T) {
 				t.Fatalf("Unmarshal(%+v) return %v, want %v", test.input, err, test.wantError)
 			}
 
-			if !cmp.Equal(got, test.want, cmp.Options{cmpopts.SortSlices(func(a, b User) bool {
-				return a.ININame > b.ININame
-			})}) {
+			if !cmp.Equal(got, test.want) {
 				t.Errorf("Unmarshal(%+v) = %v, want %v\ndiff -want +got\n%v", test.input, got, test.want, cmp.Diff(test.want, got))
 			}
 		})
 	}
 }
+
+func TestDecoder(t *testing.T) {
+	type user struct {
+		Name   string            `ini:"name"`
+		Shell  map[string]string `ini:"shell"`
+		Groups []string          `ini:"group"`
+	}
+	type config struct {
+		Version string `ini:"version"`
+		User    user   `ini:"user"`
+	}
+
+	tests := []struct {
+		description string
+		input       io.Reader
+		opts        Options
+		want        config
+		wantError   string
+	}{
+		{
+			description: "valid",
+			input:       iotest.OneByteReader(strings.NewReader("version=1\n\n[user]\r\nname=root\r\nshell[unix]=/bin/bash\r\ngroup=wheel\r\ngroup=video\r\n")),
+			want: config{
+				Version: "1",
+				User: user{
+					Name:   "root",
+					Shell:  map[string]string{"unix": "/bin/bash"},
+					Groups: []string{"wheel", "video"},
+				},
+			},
+		},
+		{
+			description: "options",
+			input:       strings.NewReader("# comment\n[user]\nname=root\n  admin\n"),
+			opts:        Options{AllowNumberSignComments: true, AllowMultilineValues: true},
+			want: config{
+				User: user{
+					Name:   "root\n  admin",
+					Shell:  map[string]string{},
+					Groups: []string{},
+				},
+			},
+		},
+		{
+			description: "syntax error",
+			input:       strings.NewReader("version=1\n[user\n"),
+			wantError:   `2:6: unexpected character: '\n', sections must be closed with a ']'`,
+		},
+		{
+			description: "read error",
+			input:       io.MultiReader(strings.NewReader("version=1\n"), iotest.ErrReader(errors.New("connection reset"))),
+			wantError:   "connection reset",
+		},
+		{
+			description: "empty",
+			input:       strings.NewReader(""),
+			wantError:   io.EOF.Error(),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			d := NewDecoder(test.input)
+			d.SetOptions(test.opts)
+
+			var got config
+			err := d.Decode(&got)
+			if test.wantError != "" {
+				if err == nil || err.Error() != test.wantError {
+					t.Fatalf("Decode() returned %v, want %v", err, test.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode() returned %v, want nil", err)
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("Decode() = %v, want %v\ndiff -want +got\n%v", got, test.want, cmp.Diff(test.want, got))
+			}
+			if err := d.Decode(&got); err != io.EOF {
+				t.Errorf("Decode() returned %v, want %v", err, io.EOF)
+			}
+		})
+	}
+}
+
+func TestDecoderFilename(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "config.ini")
+	if err := os.WriteFile(name, []byte("[user]\nname\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var v struct{}
+	err = NewDecoder(f).Decode(&v)
+
+	var got *SyntaxError
+	if !errors.As(err, &got) {
+		t.Fatalf("Decode() returned %v, want *SyntaxError", err)
+	}
+	want := Position{Filename: name, Offset: 11, Line: 2, Column: 5}
+	if got.Pos != want {
+		t.Errorf("Decode() returned error at %v, want %v", got.Pos, want)
+	}
+}
+
+// benchmarkInput returns an INI document of n sections, each holding a comment
+// and several properties.
+func benchmarkInput(n int) []byte {
+	var buf strings.Builder
+	buf.WriteString("; generated for benchmarks\nversion=1\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "\n[user]\n; UNIX user %d\nname=user%d\nuid=%d\nshell[unix]=/bin/bash\nshell[win32]=PowerShell.exe\ngroup=wheel\ngroup=video\n", i, i, 1000+i)
+	}
+	return []byte(buf.String())
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	type user struct {
+		Name   string            `ini:"name"`
+		UID    int               `ini:"uid"`
+		Shell  map[string]string `ini:"shell"`
+		Groups []string          `ini:"group"`
+	}
+	type config struct {
+		Version string `ini:"version"`
+		Users   []user `ini:"user"`
+	}
+
+	for _, n := range []int{100, 10000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			data := benchmarkInput(n)
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var v config
+				if err := Unmarshal(data, &v); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestUnmarshalText(t *testing.T) {
+	type host struct {
+		Addr    net.IP             `ini:"addr"`
+		Aliases []netip.Addr       `ini:"alias"`
+		Origin  map[string]point   `ini:"origin"`
+		Path    map[string][]point `ini:"path"`
+	}
+	type config struct {
+		Modified time.Time `ini:"modified"`
+		Name     lower     `ini:"name"`
+		Host     host      `ini:"host"`
+	}
+
+	input := `modified=2021-03-04T05:06:07Z
+name=ROOT
+
+[host]
+addr=192.0.2.1
+alias=192.0.2.2
+alias=2001:db8::1
+origin[a]=(1,2)
+path[a]=(1,2)
+path[a]=(3,4)
+`
+	want := config{
+		Modified: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+		Name:     lower{s: new(string)},
+		Host: host{
+			Addr:    net.IPv4(192, 0, 2, 1),
+			Aliases: []netip.Addr{netip.MustParseAddr("192.0.2.2"), netip.MustParseAddr("2001:db8::1")},
+			Origin:  map[string]point{"a": {1, 2}},
+			Path:    map[string][]point{"a": {{1, 2}, {3, 4}}},
+		},
+	}
+	*want.Name.s = "root"
+
+	got := config{Name: lower{s: new(string)}}
+	if err := Unmarshal([]byte(input), &got); err != nil {
+		t.Fatalf("Unmarshal() returned %v, want nil", err)
+	}
+	opts := cmp.Options{cmp.AllowUnexported(point{}, lower{}), cmp.Comparer(func(a, b netip.Addr) bool { return a == b })}
+	if !cmp.Equal(got, want, opts) {
+		t.Errorf("Unmarshal() = %v, want %v\ndiff -want +got\n%v", got, want, cmp.Diff(want, got, opts))
+	}
+
+	if err := Unmarshal([]byte("[host]\naddr=192.0.2"), &got); err == nil {
+		t.Errorf("Unmarshal() returned nil, want error")
+	}
+}
+
+func TestMarshalUnmarshalText(t *testing.T) {
+	type config struct {
+		Modified time.Time    `ini:"modified"`
+		Addrs    []netip.Addr `ini:"addr"`
+		Origin   point        `ini:"origin"`
+	}
+	want := config{
+		Modified: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+		Addrs:    []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1")},
+		Origin:   point{1, 2},
+	}
+
+	data, err := Marshal(want)
+	if err != nil {
+		t.Fatalf("Marshal() returned %v, want nil", err)
+	}
+	var got config
+	if err := Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal(%q) returned %v, want nil", data, err)
+	}
+	opts := cmp.Options{cmp.AllowUnexported(point{}), cmp.Comparer(func(a, b netip.Addr) bool { return a == b })}
+	if !cmp.Equal(got, want, opts) {
+		t.Errorf("Unmarshal(%q) = %v, want %v", data, got, want)
+	}
+}
+
+func TestUnmarshalINI(t *testing.T) {
+	type app struct {
+		Name    string  `ini:"name"`
+		Version version `ini:"version"`
+	}
+	type config struct {
+		Version version       `ini:"version"`
+		Env     environment   `ini:"env"`
+		Envs    []environment `ini:"profile"`
+		App     app           `ini:"app"`
+	}
+
+	tests := []struct {
+		description string
+		input       string
+		want        config
+		wantError   string
+	}{
+		{
+			description: "unmarshalers",
+			input:       "version[major]=1\nversion[minor]=2\n[env]\nPATH=/bin\nHOME=/root\nPATH=/usr/bin\n[profile]\nA=1\n[profile]\nB=2\n[app]\nname=ini\nversion[minor]=3\nversion[major]=0\n",
+			want: config{
+				Version: version{1, 2},
+				Env:     environment{"PATH": {"/bin", "/usr/bin"}, "HOME": {"/root"}},
+				Envs:    []environment{{"A": {"1"}}, {"B": {"2"}}},
+				App:     app{Name: "ini", Version: version{0, 3}},
+			},
+		},
+		{
+			description: "unmarshaler error",
+			input:       "version[major]=one\n[env]\n[profile]\n[app]\n",
+			wantError:   `strconv.Atoi: parsing "one": invalid syntax`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var got config
+			err := Unmarshal([]byte(test.input), &got)
+			if test.wantError != "" {
+				if err == nil || err.Error() != test.wantError {
+					t.Fatalf("Unmarshal(%q) returned %v, want %v", test.input, err, test.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal(%q) returned %v, want nil", test.input, err)
+			}
+			if !cmp.Equal(got, test.want, cmp.AllowUnexported(version{})) {
+				t.Errorf("Unmarshal(%q) = %v, want %v\ndiff -want +got\n%v", test.input, got, test.want, cmp.Diff(test.want, got, cmp.AllowUnexported(version{})))
+			}
+		})
+	}
+}
+
+func TestUnmarshalPointer(t *testing.T) {
+	ptr := func(v any) any {
+		rv := reflect.New(reflect.TypeOf(v))
+		rv.Elem().Set(reflect.ValueOf(v))
+		return rv.Interface()
+	}
+	type user struct {
+		Name  *string  `ini:"name"`
+		UID   **int    `ini:"uid"`
+		Shell *string  `ini:"shell"`
+		Since *version `ini:"since"`
+	}
+	type config struct {
+		Debug   *bool              `ini:"debug"`
+		Timeout *time.Duration     `ini:"timeout"`
+		Addr    *netip.Addr        `ini:"addr"`
+		Ports   []*int             `ini:"port"`
+		Labels  map[string]*string `ini:"label"`
+		User    *user              `ini:"user"`
+		Env     *environment       `ini:"env"`
+		Role    *user              `ini:"role"`
+	}
+
+	tests := []struct {
+		description string
+		input       string
+		want        config
+	}{
+		{
+			description: "present",
+			input:       "debug=false\ntimeout=5s\naddr=127.0.0.1\nport=80\nport=443\nlabel[tier]=web\n[user]\nname=root\nuid=0\nsince[major]=1\nsince[minor]=0\n[env]\nPATH=/bin\n",
+			want: config{
+				Debug:   ptr(false).(*bool),
+				Timeout: ptr(5 * time.Second).(*time.Duration),
+				Addr:    ptr(netip.MustParseAddr("127.0.0.1")).(*netip.Addr),
+				Ports:   []*int{ptr(80).(*int), ptr(443).(*int)},
+				Labels:  map[string]*string{"tier": ptr("web").(*string)},
+				User: &user{
+					Name:  ptr("root").(*string),
+					UID:   ptr(ptr(0).(*int)).(**int),
+					Since: &version{major: 1},
+				},
+				Env: &environment{"PATH": {"/bin"}},
+			},
+		},
+		{
+			description: "absent",
+			input:       "",
+			want:        config{},
+		},
+		{
+			description: "empty section",
+			input:       "[user]\n",
+			want:        config{User: &user{}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var got config
+			if err := Unmarshal([]byte(test.input), &got); err != nil {
+				t.Fatalf("Unmarshal(%q) returned %v, want nil", test.input, err)
+			}
+			opts := cmp.Options{cmp.AllowUnexported(version{}), cmp.Comparer(func(a, b netip.Addr) bool { return a == b }), cmpopts.EquateEmpty()}
+			if !cmp.Equal(got, test.want, opts) {
+				t.Errorf("Unmarshal(%q) = %v, want %v\ndiff -want +got\n%v", test.input, got, test.want, cmp.Diff(test.want, got, opts))
+			}
+		})
+	}
+}
+
+func TestUnmarshalNil(t *testing.T) {
+	tests := []struct {
+		description string
+		v           interface{}
+	}{
+		{description: "nil", v: nil},
+		{description: "nil pointer", v: (*struct{})(nil)},
+		{description: "non-pointer", v: struct{}{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			err := Unmarshal([]byte("name=root"), test.v)
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) {
+				t.Errorf("Unmarshal() returned %v, want *DecodeError", err)
+			}
+		})
+	}
+}
+
+func TestUnmarshalPointerToPointer(t *testing.T) {
+	type config struct {
+		Name string `ini:"name"`
+	}
+
+	var got *config
+	if err := Unmarshal([]byte("name=root"), &got); err != nil {
+		t.Fatalf("Unmarshal() returned %v, want nil", err)
+	}
+	if want := (&config{Name: "root"}); !cmp.Equal(got, want) {
+		t.Errorf("Unmarshal() = %v, want %v", got, want)
+	}
+}
+
+func TestMarshalUnmarshalPointer(t *testing.T) {
+	ptr := func(v any) any {
+		rv := reflect.New(reflect.TypeOf(v))
+		rv.Elem().Set(reflect.ValueOf(v))
+		return rv.Interface()
+	}
+	type user struct {
+		Name  *string `ini:"name"`
+		UID   **int   `ini:"uid"`
+		Shell *string `ini:"shell"`
+	}
+	type config struct {
+		Debug  *bool              `ini:"debug"`
+		Ports  []*int             `ini:"port"`
+		Labels map[string]*string `ini:"label"`
+		Hosts  *[]string          `ini:"host"`
+		Env    *map[string]string `ini:"env"`
+		User   *user              `ini:"user"`
+		Role   *user              `ini:"role"`
+	}
+
+	tests := []struct {
+		description string
+		input       config
+		wantData    string
+	}{
+		{
+			description: "set",
+			input: config{
+				Debug:  ptr(false).(*bool),
+				Ports:  []*int{ptr(80).(*int), ptr(443).(*int)},
+				Labels: map[string]*string{"tier": ptr("web").(*string)},
+				Hosts:  &[]string{"alpha", "beta"},
+				Env:    &map[string]string{"PATH": "/bin"},
+				User:   &user{Name: ptr("root").(*string), UID: ptr(ptr(0).(*int)).(**int)},
+			},
+			wantData: "debug=false\nport=80\nport=443\nlabel[tier]=web\nhost=alpha\nhost=beta\nenv[PATH]=/bin\n\n[user]\nname=root\nuid=0",
+		},
+		{
+			description: "nil",
+			input:       config{User: &user{}},
+			wantData:    "[user]",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			data, err := Marshal(test.input)
+			if err != nil {
+				t.Fatalf("Marshal() returned %v, want nil", err)
+			}
+			if string(data) != test.wantData {
+				t.Errorf("Marshal() = %q, want %q", data, test.wantData)
+			}
+
+			var got config
+			if err := Unmarshal(data, &got); err != nil {
+				t.Fatalf("Unmarshal(%q) returned %v, want nil", data, err)
+			}
+			if !cmp.Equal(got, test.input, cmpopts.EquateEmpty()) {
+				t.Errorf("Unmarshal(%q) = %v, want %v\ndiff -want +got\n%v", data, got, test.input, cmp.Diff(test.input, got, cmpopts.EquateEmpty()))
+			}
+		})
+	}
+}
+
+func TestUnmarshalNested(t *testing.T) {
+	type ca struct {
+		File string `ini:"file"`
+	}
+	type tls struct {
+		Cert string `ini:"cert"`
+		CA   *ca    `ini:"ca"`
+	}
+	type server struct {
+		Addr string `ini:"addr"`
+		TLS  tls    `ini:"tls,required"`
+	}
+	type config struct {
+		Server server `ini:"server"`
+	}
+
+	tests := []struct {
+		description string
+		input       string
+		want        config
+		wantError   string
+	}{
+		{
+			description: "dotted",
+			input:       "[server]\naddr=:443\n[server.tls]\ncert=server.pem\n[server.tls.ca]\nfile=ca.pem\n",
+			want:        config{Server: server{Addr: ":443", TLS: tls{Cert: "server.pem", CA: &ca{File: "ca.pem"}}}},
+		},
+		{
+			description: "quoted",
+			input:       "[server]\naddr=:443\n[server \"tls\"]\ncert=server.pem\n[server \"tls.ca\"]\nfile=ca.pem\n",
+			want:        config{Server: server{Addr: ":443", TLS: tls{Cert: "server.pem", CA: &ca{File: "ca.pem"}}}},
+		},
+		{
+			description: "intermediate sections omitted",
+			input:       "[server.tls.ca]\nfile=ca.pem\n",
+			want:        config{Server: server{TLS: tls{CA: &ca{File: "ca.pem"}}}},
+		},
+		{
+			description: "nested pointer absent",
+			input:       "[server]\n[server.tls]\ncert=server.pem\n",
+			want:        config{Server: server{TLS: tls{Cert: "server.pem"}}},
+		},
+		{
+			description: "nested section missing",
+			input:       "[server]\naddr=:443\n",
+			wantError:   `ini: missing required section "server.tls"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var got config
+			err := Unmarshal([]byte(test.input), &got)
+			if test.wantError != "" {
+				if err == nil || err.Error() != test.wantError {
+					t.Fatalf("Unmarshal(%q) returned %v, want %v", test.input, err, test.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal(%q) returned %v, want nil", test.input, err)
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("Unmarshal(%q) = %v, want %v\ndiff -want +got\n%v", test.input, got, test.want, cmp.Diff(test.want, got))
+			}
+		})
+	}
+}
+
+func TestMarshalUnmarshalTime(t *testing.T) {
+	type session struct {
+		Timeout  time.Duration   `ini:"timeout"`
+		Interval time.Duration   `ini:"interval,seconds"`
+		Retries  []time.Duration `ini:"retry"`
+		Expires  time.Time       `ini:"expires,layout=2006-01-02"`
+		Started  time.Time       `ini:"started"`
+	}
+	type config struct {
+		Session session `ini:"session"`
+	}
+	want := config{
+		Session: session{
+			Timeout:  90 * time.Second,
+			Interval: 1500 * time.Millisecond,
+			Retries:  []time.Duration{time.Second, time.Minute},
+			Expires:  time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC),
+			Started:  time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+		},
+	}
+	wantData := "[session]\ntimeout=1m30s\ninterval=1.5\nretry=1s\nretry=1m0s\nexpires=2021-03-04\nstarted=2021-03-04T05:06:07Z"
+
+	data, err := Marshal(want)
+	if err != nil {
+		t.Fatalf("Marshal() returned %v, want nil", err)
+	}
+	if string(data) != wantData {
+		t.Errorf("Marshal() = %q, want %q", data, wantData)
+	}
+
+	var got config
+	if err := Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal(%q) returned %v, want nil", data, err)
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("Unmarshal(%q) = %v, want %v\ndiff -want +got\n%v", data, got, want, cmp.Diff(want, got))
+	}
+
+	if err := Unmarshal([]byte("[session]\ninterval=30\ntimeout=30"), &got); err == nil {
+		t.Errorf("Unmarshal() returned nil, want error for a duration without a unit")
+	}
+}
+
+func TestMarshalUnmarshalTimePointer(t *testing.T) {
+	type session struct {
+		Timeout  *time.Duration `ini:"timeout"`
+		Interval *time.Duration `ini:"interval,seconds"`
+		Expires  *time.Time     `ini:"expires,layout=2006-01-02"`
+		Started  *time.Time     `ini:"started"`
+	}
+	type config struct {
+		Session session `ini:"session"`
+	}
+
+	timeout := 90 * time.Second
+	interval := 1500 * time.Millisecond
+	expires := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		description string
+		input       config
+		opts        Options
+		wantData    string
+	}{
+		{
+			description: "set",
+			input:       config{Session: session{Timeout: &timeout, Interval: &interval, Expires: &expires}},
+			wantData:    "[session]\ntimeout=1m30s\ninterval=1.5\nexpires=2021-03-04",
+		},
+		{
+			description: "nil",
+			input:       config{},
+			wantData:    "[session]",
+		},
+		{
+			description: "nil with empty values",
+			input:       config{},
+			opts:        Options{WriteEmptyValues: true},
+			wantData:    "[session]\ntimeout=\"\"\ninterval=\"\"\nexpires=\"\"\nstarted=\"\"",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			data, err := MarshalWithOptions(test.input, test.opts)
+			if err != nil {
+				t.Fatalf("MarshalWithOptions() returned %v, want nil", err)
+			}
+			if string(data) != test.wantData {
+				t.Errorf("MarshalWithOptions() = %q, want %q", data, test.wantData)
+			}
+			if test.opts.WriteEmptyValues {
+				return
+			}
+
+			var got config
+			if err := UnmarshalWithOptions(data, &got, test.opts); err != nil {
+				t.Fatalf("UnmarshalWithOptions(%q) returned %v, want nil", data, err)
+			}
+			if !cmp.Equal(got, test.input) {
+				t.Errorf("UnmarshalWithOptions(%q) = %v, want %v\ndiff -want +got\n%v", data, got, test.input, cmp.Diff(test.input, got))
+			}
+		})
+	}
+}
+
+func TestUnmarshalSectionMap(t *testing.T) {
+	type tls struct {
+		Cert string `ini:"cert"`
+	}
+	type remote struct {
+		URL   string   `ini:"url"`
+		Fetch []string `ini:"fetch"`
+		TLS   *tls     `ini:"tls"`
+	}
+	type branch struct {
+		Remote string `ini:"remote"`
+	}
+	type config struct {
+		Remotes  map[string]remote      `ini:"remote,required"`
+		Branches map[string]*branch     `ini:"branch"`
+		Envs     map[string]environment `ini:"env"`
+	}
+
+	tests := []struct {
+		description string
+		input       string
+		want        config
+		wantError   string
+	}{
+		{
+			description: "quoted",
+			input:       "[remote \"origin\"]\nurl=https://example.com/origin.git\nfetch=+refs/heads/*:refs/remotes/origin/*\n[remote \"upstream\"]\nurl=https://example.com/upstream.git\n[branch \"main\"]\nremote=origin\n[env \"prod\"]\nPATH=/bin\n",
+			want: config{
+				Remotes: map[string]remote{
+					"origin":   {URL: "https://example.com/origin.git", Fetch: []string{"+refs/heads/*:refs/remotes/origin/*"}},
+					"upstream": {URL: "https://example.com/upstream.git"},
+				},
+				Branches: map[string]*branch{"main": {Remote: "origin"}},
+				Envs:     map[string]environment{"prod": {"PATH": {"/bin"}}},
+			},
+		},
+		{
+			description: "dotted and nested",
+			input:       "[remote.origin]\nurl=https://example.com/origin.git\n[remote \"origin\".tls]\ncert=origin.pem\n[remote \"origin\"]\nurl=ignored\n[branch \"main\"]\n[env \"prod\"]\n",
+			want: config{
+				Remotes: map[string]remote{
+					"origin": {URL: "https://example.com/origin.git", TLS: &tls{Cert: "origin.pem"}},
+				},
+				Branches: map[string]*branch{"main": {}},
+				Envs:     map[string]environment{"prod": {}},
+			},
+		},
+		{
+			description: "quoted with dots",
+			input:       "[remote \"v1\"]\nurl=v1.git\n[remote \"v1.2\"]\nurl=v1.2.git\n[remote \"v1.2\".tls]\ncert=v1.2.pem\n[branch \"release/1.0\"]\n",
+			want: config{
+				Remotes: map[string]remote{
+					"v1":   {URL: "v1.git"},
+					"v1.2": {URL: "v1.2.git", TLS: &tls{Cert: "v1.2.pem"}},
+				},
+				Branches: map[string]*branch{"release/1.0": {}},
+			},
+		},
+		{
+			description: "missing",
+			input:       "[remote]\nurl=https://example.com/origin.git\n",
+			wantError:   `ini: missing required section "remote"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var got config
+			err := Unmarshal([]byte(test.input), &got)
+			if test.wantError != "" {
+				if err == nil || err.Error() != test.wantError {
+					t.Fatalf("Unmarshal(%q) returned %v, want %v", test.input, err, test.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal(%q) returned %v, want nil", test.input, err)
+			}
+			if !cmp.Equal(got, test.want, cmpopts.EquateEmpty()) {
+				t.Errorf("Unmarshal(%q) = %v, want %v\ndiff -want +got\n%v", test.input, got, test.want, cmp.Diff(test.want, got, cmpopts.EquateEmpty()))
+			}
+		})
+	}
+}
+
+func TestUnmarshalPattern(t *testing.T) {
+	type program struct {
+		ININameMatch string `ini:"-"`
+		Command      string `ini:"command"`
+	}
+	type profile struct {
+		ININame      string
+		ININameMatch *string `ini:"-"`
+		Region       string  `ini:"region"`
+	}
+	type remote struct {
+		ININameMatch string `ini:"-"`
+		URL          string `ini:"url"`
+	}
+	type config struct {
+		Programs []program          `ini:"program:*"`
+		Profiles map[string]profile `ini:"profile *"`
+		Remotes  map[string]remote  `ini:"remote"`
+		Default  profile            `ini:"default"`
+	}
+
+	input := `[default]
+region=us-east-1
+
+[program:web]
+command=/usr/bin/web
+
+[profile dev]
+region=eu-west-1
+
+[program:worker]
+command=/usr/bin/worker
+
+[profile prod]
+region=us-west-2
+
+[remote "origin"]
+url=https://example.com/origin.git
+`
+	dev, prod := "dev", "prod"
+	want := config{
+		Programs: []program{
+			{ININameMatch: "web", Command: "/usr/bin/web"},
+			{ININameMatch: "worker", Command: "/usr/bin/worker"},
+		},
+		Profiles: map[string]profile{
+			"dev":  {ININame: "profile dev", ININameMatch: &dev, Region: "eu-west-1"},
+			"prod": {ININame: "profile prod", ININameMatch: &prod, Region: "us-west-2"},
+		},
+		Remotes: map[string]remote{
+			"origin": {ININameMatch: "origin", URL: "https://example.com/origin.git"},
+		},
+		Default: profile{ININame: "default", Region: "us-east-1"},
+	}
+
+	var got config
+	if err := Unmarshal([]byte(input), &got); err != nil {
+		t.Fatalf("Unmarshal(%q) returned %v, want nil", input, err)
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("Unmarshal(%q) = %v, want %v\ndiff -want +got\n%v", input, got, want, cmp.Diff(want, got))
+	}
+}
+
+func TestMarshalUnmarshalPattern(t *testing.T) {
+	type program struct {
+		Command string `ini:"command"`
+	}
+	type config struct {
+		Programs map[string]program `ini:"program:*"`
+		Profiles map[string]program `ini:"profile *"`
+	}
+
+	input := config{
+		Programs: map[string]program{
+			"web":    {Command: "/usr/bin/web"},
+			"worker": {Command: "/usr/bin/worker"},
+		},
+		Profiles: map[string]program{
+			"dev": {Command: "/usr/bin/dev"},
+		},
+	}
+	want := "[program:web]\ncommand=/usr/bin/web\n\n[program:worker]\ncommand=/usr/bin/worker\n\n[profile dev]\ncommand=/usr/bin/dev"
+
+	got, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal(%#v) returned %v, want nil", input, err)
+	}
+	if string(got) != want {
+		t.Errorf("Marshal(%#v) = %q, want %q", input, got, want)
+	}
+
+	var rt config
+	if err := UnmarshalWithOptions(got, &rt, Options{DisallowUnknownSections: true}); err != nil {
+		t.Fatalf("UnmarshalWithOptions(%q) returned %v, want nil", got, err)
+	}
+	if !cmp.Equal(rt, input) {
+		t.Errorf("UnmarshalWithOptions(%q) = %v, want %v\ndiff -want +got\n%v", got, rt, input, cmp.Diff(input, rt))
+	}
+
+	type invalid struct {
+		Programs map[string]program `ini:"program:*:*"`
+	}
+	if _, err := Marshal(invalid{Programs: input.Programs}); err == nil {
+		t.Errorf("Marshal() returned nil, want an error for a pattern with two asterisks")
+	}
+}
+
+func TestUnmarshalMap(t *testing.T) {
+	input := `source=passwd
+
+[user]
+name=root
+shell[unix]=/bin/bash
+shell[win32]=PowerShell.exe
+group=wheel
+group=video
+
+[user]
+name=admin
+uid=1001
+`
+
+	tests := []struct {
+		description string
+		init        func() interface{}
+		want        interface{}
+		wantError   string
+	}{
+		{
+			description: "map of string maps",
+			init:        func() interface{} { return new(map[string]map[string]string) },
+			want: &map[string]map[string]string{
+				"":     {"source": "passwd"},
+				"user": {"name": "root", "shell[unix]": "/bin/bash", "shell[win32]": "PowerShell.exe", "group": "wheel", "uid": "1001"},
+			},
+		},
+		{
+			description: "map of slice maps",
+			init:        func() interface{} { return new(map[string]map[string][]string) },
+			want: &map[string]map[string][]string{
+				"":     {"source": {"passwd"}},
+				"user": {"name": {"root", "admin"}, "shell[unix]": {"/bin/bash"}, "shell[win32]": {"PowerShell.exe"}, "group": {"wheel", "video"}, "uid": {"1001"}},
+			},
+		},
+		{
+			description: "map of lists of maps",
+			init:        func() interface{} { return new(map[string][]map[string]string) },
+			want: &map[string][]map[string]string{
+				"":     {{"source": "passwd"}},
+				"user": {{"name": "root", "shell[unix]": "/bin/bash", "shell[win32]": "PowerShell.exe", "group": "wheel"}, {"name": "admin", "uid": "1001"}},
+			},
+		},
+		{
+			description: "map of interfaces",
+			init:        func() interface{} { return new(map[string]interface{}) },
+			want: &map[string]interface{}{
+				"": map[string]interface{}{"source": "passwd"},
+				"user": []interface{}{
+					map[string]interface{}{
+						"name":  "root",
+						"shell": map[string]interface{}{"unix": "/bin/bash", "win32": "PowerShell.exe"},
+						"group": []interface{}{"wheel", "video"},
+					},
+					map[string]interface{}{"name": "admin", "uid": "1001"},
+				},
+			},
+		},
+		{
+			description: "interface",
+			init:        func() interface{} { return new(interface{}) },
+			want: func() interface{} {
+				var v interface{} = map[string]interface{}{
+					"": map[string]interface{}{"source": "passwd"},
+					"user": []interface{}{
+						map[string]interface{}{
+							"name":  "root",
+							"shell": map[string]interface{}{"unix": "/bin/bash", "win32": "PowerShell.exe"},
+							"group": []interface{}{"wheel", "video"},
+						},
+						map[string]interface{}{"name": "admin", "uid": "1001"},
+					},
+				}
+				return &v
+			}(),
+		},
+		{
+			description: "pre-filled map of string maps",
+			init: func() interface{} {
+				return &map[string]map[string]string{
+					"user":  {"name": "nobody", "home": "/"},
+					"group": {"name": "wheel"},
+				}
+			},
+			want: &map[string]map[string]string{
+				"":      {"source": "passwd"},
+				"user":  {"name": "root", "shell[unix]": "/bin/bash", "shell[win32]": "PowerShell.exe", "group": "wheel", "uid": "1001"},
+				"group": {"name": "wheel"},
+			},
+		},
+		{
+			description: "pre-filled map of interfaces",
+			init: func() interface{} {
+				return &map[string]interface{}{"user": "nobody", "group": "wheel"}
+			},
+			want: &map[string]interface{}{
+				"": map[string]interface{}{"source": "passwd"},
+				"user": []interface{}{
+					map[string]interface{}{
+						"name":  "root",
+						"shell": map[string]interface{}{"unix": "/bin/bash", "win32": "PowerShell.exe"},
+						"group": []interface{}{"wheel", "video"},
+					},
+					map[string]interface{}{"name": "admin", "uid": "1001"},
+				},
+				"group": "wheel",
+			},
+		},
+		{
+			description: "typed values",
+			init:        func() interface{} { return new(map[string]map[string]int) },
+			wantError:   `strconv.ParseInt: parsing "passwd": invalid syntax`,
+		},
+		{
+			description: "unsupported section type",
+			init:        func() interface{} { return new(map[string]string) },
+			wantError:   "ini: cannot unmarshal section into Go value of type string",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			got := test.init()
+			err := Unmarshal([]byte(input), got)
+			if test.wantError != "" {
+				if err == nil || err.Error() != test.wantError {
+					t.Fatalf("Unmarshal(%q) returned %v, want %v", input, err, test.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal(%q) returned %v, want nil", input, err)
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("Unmarshal(%q) = %v, want %v\ndiff -want +got\n%v", input, got, test.want, cmp.Diff(test.want, got))
+			}
+		})
+	}
+}
+
+func TestUnmarshalRequired(t *testing.T) {
+	type user struct {
+		Name  string `ini:"name,required"`
+		Shell string `ini:"shell"`
+	}
+	type group struct {
+		GID int `ini:"gid,required"`
+	}
+	type config struct {
+		Version string           `ini:"version,required"`
+		User    user             `ini:"user,required"`
+		Admin   *user            `ini:"admin,required"`
+		Groups  []group          `ini:"group"`
+		Remotes map[string]user  `ini:"remote,required"`
+		Other   map[string]group `ini:"other"`
+		Guest   user             `ini:"guest"`
+	}
+
+	tests := []struct {
+		description string
+		input       string
+		want        config
+		wantError   []string
+	}{
+		{
+			description: "present",
+			input:       "version=1\n[user]\nname=root\n[admin]\nname=admin\n[remote \"origin\"]\nname=origin\n",
+			want: config{
+				Version: "1",
+				User:    user{Name: "root"},
+				Admin:   &user{Name: "admin"},
+				Remotes: map[string]user{"origin": {Name: "origin"}},
+			},
+		},
+		{
+			description: "missing",
+			input:       "[user]\nshell=/bin/sh\n[group]\ngid=0\n[group]\nname=video\n",
+			want: config{
+				User:   user{Shell: "/bin/sh"},
+				Groups: []group{{GID: 0}, {}},
+			},
+			wantError: []string{
+				`ini: missing required key "version"`,
+				`ini: missing required key "name" in section "user"`,
+				`ini: missing required section "admin"`,
+				`ini: missing required key "gid" in section "group"`,
+				`ini: missing required section "remote"`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var got config
+			err := Unmarshal([]byte(test.input), &got)
+			if test.wantError == nil {
+				if err != nil {
+					t.Fatalf("Unmarshal(%q) returned %v, want nil", test.input, err)
+				}
+			} else {
+				joined, ok := err.(interface{ Unwrap() []error })
+				if !ok {
+					t.Fatalf("Unmarshal(%q) returned %v, want joined errors", test.input, err)
+				}
+				gotError := make([]string, 0)
+				for _, err := range joined.Unwrap() {
+					var missing *MissingError
+					if !errors.As(err, &missing) {
+						t.Errorf("Unmarshal(%q) returned %T, want *MissingError", test.input, err)
+					}
+					gotError = append(gotError, err.Error())
+				}
+				if !cmp.Equal(gotError, test.wantError) {
+					t.Errorf("Unmarshal(%q) returned %v, want %v\ndiff -want +got\n%v", test.input, gotError, test.wantError, cmp.Diff(test.wantError, gotError))
+				}
+			}
+			if !cmp.Equal(got, test.want, cmpopts.EquateEmpty()) {
+				t.Errorf("Unmarshal(%q) = %v, want %v\ndiff -want +got\n%v", test.input, got, test.want, cmp.Diff(test.want, got, cmpopts.EquateEmpty()))
+			}
+		})
+	}
+}
+
+func TestUnmarshalDisallowUnknown(t *testing.T) {
+	type tls struct {
+		Cert string `ini:"cert"`
+	}
+	type user struct {
+		Name     string `ini:"name"`
+		Password string `ini:"password"`
+		TLS      *tls   `ini:"tls"`
+	}
+	type config struct {
+		Version string          `ini:"version"`
+		User    user            `ini:"user"`
+		Remotes map[string]user `ini:"remote"`
+		Env     environment     `ini:"env"`
+	}
+
+	input := `version=1
+verison=2
+
+[user]
+name=root
+pasword=swordfish
+pasword=hunter2
+
+[user.tls]
+cert=user.pem
+key=user.key
+
+[remote "origin"]
+name=origin
+
+[remote "origin".tsl]
+cert=origin.pem
+
+[env]
+ANYTHING=goes
+
+[group]
+name=wheel
+`
+
+	tests := []struct {
+		description string
+		opts        Options
+		wantError   []string
+	}{
+		{
+			description: "allowed",
+		},
+		{
+			description: "unknown keys",
+			opts:        Options{DisallowUnknownKeys: true},
+			wantError: []string{
+				`2:1: unknown key "verison"`,
+				`6:1: unknown key "pasword" in section "user"`,
+				`11:1: unknown key "key" in section "user.tls"`,
+			},
+		},
+		{
+			description: "unknown sections",
+			opts:        Options{DisallowUnknownSections: true},
+			wantError: []string{
+				`16:1: unknown section "remote \"origin\".tsl"`,
+				`22:1: unknown section "group"`,
+			},
+		},
+		{
+			description: "unknown keys and sections",
+			opts:        Options{DisallowUnknownKeys: true, DisallowUnknownSections: true},
+			wantError: []string{
+				`2:1: unknown key "verison"`,
+				`6:1: unknown key "pasword" in section "user"`,
+				`11:1: unknown key "key" in section "user.tls"`,
+				`16:1: unknown section "remote \"origin\".tsl"`,
+				`22:1: unknown section "group"`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var got config
+			err := UnmarshalWithOptions([]byte(input), &got, test.opts)
+			if test.wantError == nil {
+				if err != nil {
+					t.Fatalf("UnmarshalWithOptions(%q) returned %v, want nil", input, err)
+				}
+				return
+			}
+			joined, ok := err.(interface{ Unwrap() []error })
+			if !ok {
+				t.Fatalf("UnmarshalWithOptions(%q) returned %v, want joined errors", input, err)
+			}
+			gotError := make([]string, 0)
+			for _, err := range joined.Unwrap() {
+				var unknown *UnknownError
+				if !errors.As(err, &unknown) {
+					t.Errorf("UnmarshalWithOptions(%q) returned %T, want *UnknownError", input, err)
+				}
+				gotError = append(gotError, err.Error())
+			}
+			if !cmp.Equal(gotError, test.wantError) {
+				t.Errorf("UnmarshalWithOptions(%q) returned %v, want %v\ndiff -want +got\n%v", input, gotError, test.wantError, cmp.Diff(test.wantError, gotError))
+			}
+			if want := "root"; got.User.Name != want {
+				t.Errorf("UnmarshalWithOptions(%q) decoded user name %q, want %q", input, got.User.Name, want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalDefault(t *testing.T) {
+	type tls struct {
+		Enabled bool `ini:"enabled,default=true"`
+	}
+	type database struct {
+		Host    string            `ini:"host,default=localhost"`
+		Port    int               `ini:"port,default=5432"`
+		Timeout time.Duration     `ini:"timeout,default=30s"`
+		Replica []string          `ini:"replica,default=db1,db2"`
+		Options map[string]string `ini:"option,default=sslmode=disable,connect_timeout=10"`
+		Version version           `ini:"version,default="`
+		Name    *string           `ini:"name,default=postgres"`
+		TLS     tls               `ini:"tls"`
+	}
+	type config struct {
+		Debug    bool      `ini:"debug,default=true"`
+		Database database  `ini:"database"`
+		Replica  *database `ini:"replica"`
+	}
+
+	name := "postgres"
+	defaults := database{
+		Host:    "localhost",
+		Port:    5432,
+		Timeout: 30 * time.Second,
+		Replica: []string{"db1", "db2"},
+		Options: map[string]string{"sslmode": "disable", "connect_timeout": "10"},
+		Name:    &name,
+		TLS:     tls{Enabled: true},
+	}
+
+	tests := []struct {
+		description string
+		input       string
+		want        config
+	}{
+		{
+			description: "absent section",
+			input:       "",
+			want:        config{Debug: true, Database: defaults},
+		},
+		{
+			description: "absent keys",
+			input:       "[database]\n[replica]\n",
+			want:        config{Debug: true, Database: defaults, Replica: &defaults},
+		},
+		{
+			description: "present keys",
+			input:       "debug=false\n[database]\nhost=db\nport=5433\ntimeout=1m\nreplica=db3\noption[sslmode]=require\nversion[major]=1\nversion[minor]=0\nname=app\n[database.tls]\nenabled=false\n",
+			want: config{
+				Database: database{
+					Host:    "db",
+					Port:    5433,
+					Timeout: time.Minute,
+					Replica: []string{"db3"},
+					Options: map[string]string{"sslmode": "require"},
+					Version: version{1, 0},
+					Name:    func() *string { s := "app"; return &s }(),
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var got config
+			if err := Unmarshal([]byte(test.input), &got); err != nil {
+				t.Fatalf("Unmarshal(%q) returned %v, want nil", test.input, err)
+			}
+			if !cmp.Equal(got, test.want, cmp.AllowUnexported(version{})) {
+				t.Errorf("Unmarshal(%q) = %v, want %v\ndiff -want +got\n%v", test.input, got, test.want, cmp.Diff(test.want, got, cmp.AllowUnexported(version{})))
+			}
+		})
+	}
+}
+
+func TestUnmarshalNaming(t *testing.T) {
+	type server struct {
+		HostName   string
+		MaxRetries int
+		LogLevel   string `ini:"verbosity"`
+	}
+	type config struct {
+		DryRun     bool
+		Server     server
+		BackupHost []server
+	}
+
+	tests := []struct {
+		description string
+		input       string
+		opts        Options
+		want        config
+		wantError   string
+	}{
+		{
+			description: "exact",
+			input:       "DryRun=true\n[Server]\nHostName=db\nMaxRetries=3\nverbosity=debug\n",
+			want:        config{DryRun: true, Server: server{HostName: "db", MaxRetries: 3, LogLevel: "debug"}},
+		},
+		{
+			description: "case-insensitive",
+			input:       "dryrun=true\n[SERVER]\nhostname=db\nMAXRETRIES=3\nVerbosity=debug\n[backuphost]\nHostname=db2\n",
+			opts:        Options{CaseInsensitive: true},
+			want: config{
+				DryRun:     true,
+				Server:     server{HostName: "db", MaxRetries: 3, LogLevel: "debug"},
+				BackupHost: []server{{HostName: "db2"}},
+			},
+		},
+		{
+			description: "snake case",
+			input:       "dry_run=true\n[server]\nhost_name=db\nmax_retries=3\nverbosity=debug\n[backup_host]\nhost_name=db2\n",
+			opts:        Options{FieldNaming: SnakeCase},
+			want: config{
+				DryRun:     true,
+				Server:     server{HostName: "db", MaxRetries: 3, LogLevel: "debug"},
+				BackupHost: []server{{HostName: "db2"}},
+			},
+		},
+		{
+			description: "kebab case",
+			input:       "dry-run=true\n[server]\nhost-name=db\nmax-retries=3\n",
+			opts:        Options{FieldNaming: KebabCase},
+			want:        config{DryRun: true, Server: server{HostName: "db", MaxRetries: 3}},
+		},
+		{
+			description: "lower case",
+			input:       "dryrun=true\n[server]\nhostname=db\n",
+			opts:        Options{FieldNaming: strings.ToLower},
+			want:        config{DryRun: true, Server: server{HostName: "db"}},
+		},
+		{
+			description: "case-insensitive unknown key",
+			input:       "[Server]\nHOSTNAME=db\nport=5432\n",
+			opts:        Options{CaseInsensitive: true, DisallowUnknownKeys: true},
+			want:        config{Server: server{HostName: "db"}},
+			wantError:   `3:1: unknown key "port" in section "Server"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var got config
+			err := UnmarshalWithOptions([]byte(test.input), &got, test.opts)
+			if test.wantError != "" {
+				if err == nil || err.Error() != test.wantError {
+					t.Fatalf("UnmarshalWithOptions(%q) returned %v, want %v", test.input, err, test.wantError)
+				}
+			} else if err != nil {
+				t.Fatalf("UnmarshalWithOptions(%q) returned %v, want nil", test.input, err)
+			}
+			if !cmp.Equal(got, test.want, cmpopts.EquateEmpty()) {
+				t.Errorf("UnmarshalWithOptions(%q) = %v, want %v\ndiff -want +got\n%v", test.input, got, test.want, cmp.Diff(test.want, got, cmpopts.EquateEmpty()))
+			}
+		})
+	}
+}
+
+func TestUnmarshalWhitespace(t *testing.T) {
+	type user struct {
+		Name  string            `ini:"name"`
+		UID   int               `ini:"uid"`
+		Shell map[string]string `ini:"shell"`
+	}
+	type config struct {
+		User user `ini:"user"`
+	}
+
+	input := "[ user ]\nname = root \nuid\t=\t1000\nshell [ unix ] = /bin/bash\n"
+
+	var got config
+	if err := Unmarshal([]byte(input), &got); err != nil {
+		t.Fatalf("Unmarshal(%q) returned %v, want nil", input, err)
+	}
+	want := config{User: user{Name: "root", UID: 1000, Shell: map[string]string{"unix": "/bin/bash"}}}
+	if !cmp.Equal(got, want) {
+		t.Errorf("Unmarshal(%q) = %v, want %v\ndiff -want +got\n%v", input, got, want, cmp.Diff(want, got))
+	}
+
+	var raw map[string]map[string]string
+	if err := UnmarshalWithOptions([]byte(input), &raw, Options{KeepWhitespace: true}); err != nil {
+		t.Fatalf("UnmarshalWithOptions(%q) returned %v, want nil", input, err)
+	}
+	wantRaw := map[string]map[string]string{
+		" user ": {"name ": " root ", "uid\t": "\t1000", "shell [ unix ]": " /bin/bash"},
+	}
+	if !cmp.Equal(raw, wantRaw) {
+		t.Errorf("UnmarshalWithOptions(%q) = %v, want %v\ndiff -want +got\n%v", input, raw, wantRaw, cmp.Diff(wantRaw, raw))
+	}
+}
+
+func TestUnmarshalQuoted(t *testing.T) {
+	type service struct {
+		Description string `ini:"Description"`
+		ExecStart   string `ini:"ExecStart"`
+		Title       string `ini:"Title"`
+		Prompt      string `ini:"Prompt"`
+		City        string `ini:"City"`
+		Greeting    string `ini:"Greeting"`
+	}
+	type config struct {
+		Service service `ini:"Service"`
+	}
+
+	input := "[Service]\nDescription=\"Line one\\nLine two\"\nExecStart=\"/usr/bin/foo\" --bar\nTitle=\"A\" and \"B\"\nPrompt='$ '\nCity='s-Hertogenbosch\nGreeting=\"Hello\n"
+
+	var got config
+	if err := Unmarshal([]byte(input), &got); err != nil {
+		t.Fatalf("Unmarshal(%q) returned %v, want nil", input, err)
+	}
+	want := config{Service: service{
+		Description: "Line one\nLine two",
+		ExecStart:   `"/usr/bin/foo" --bar`,
+		Title:       `"A" and "B"`,
+		Prompt:      "$ ",
+		City:        "'s-Hertogenbosch",
+		Greeting:    `"Hello`,
+	}}
+	if !cmp.Equal(got, want) {
+		t.Errorf("Unmarshal(%q) = %v, want %v\ndiff -want +got\n%v", input, got, want, cmp.Diff(want, got))
+	}
+}
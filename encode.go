@@ -3,8 +3,12 @@ package ini
 import (
 	"bytes"
 	"encoding"
+	"io"
 	"reflect"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // A MarshalTypeError represents a type that cannot be encoded in an INI-compatible
@@ -17,22 +21,53 @@ func (e *MarshalTypeError) Error() string {
 	return "ini: unsupported type: " + e.typ.String()
 }
 
-// A MarshalerError represents an error from calling a MarshalText method.
+// A MarshalerError represents an error from calling a MarshalText, MarshalINI
+// or MarshalINIKey method.
 type MarshalerError struct {
-	Type reflect.Type
-	Err  error
+	Type       reflect.Type
+	Err        error
+	sourceFunc string
 }
 
 func (e *MarshalerError) Error() string {
-	return "ini: error calling MarshalText for type " + e.Type.String() + ": " + e.Err.Error()
+	sourceFunc := e.sourceFunc
+	if sourceFunc == "" {
+		sourceFunc = "MarshalText"
+	}
+	return "ini: error calling " + sourceFunc + " for type " + e.Type.String() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *MarshalerError) Unwrap() error {
+	return e.Err
+}
+
+// Marshaler is the interface implemented by types that can marshal themselves
+// into a section of an INI document. MarshalINI is called with an empty
+// section, named by the struct field being encoded, and adds the keys and
+// values of the section to it.
+type Marshaler interface {
+	MarshalINI(s *Section) error
+}
+
+// KeyMarshaler is the interface implemented by types that can marshal
+// themselves into a property key of an INI document. MarshalINIKey is called
+// with a key that has no values, named by the struct field being encoded, and
+// assigns the values and subkeys of the key to it.
+type KeyMarshaler interface {
+	MarshalINIKey(k *Key) error
 }
 
 // Marshal returns the INI encoding of v.
 //
 // Marshal traverses the value of v recursively. If an encountered value implements
-// the encoding.MarshalText interface, Marshal calls its MarshalText method and
-// encodes the result into the INI property value. Otherwise Marshal attempts to
-// encode a textual representation of the value through string formatting.
+// the Marshaler interface, Marshal calls its MarshalINI method to encode the
+// section. If an encountered value implements the KeyMarshaler interface,
+// Marshal calls its MarshalINIKey method to encode the property key. If an
+// encountered value implements the encoding.MarshalText interface, Marshal
+// calls its MarshalText method and encodes the result into the INI property
+// value. Otherwise Marshal attempts to encode a textual representation of the
+// value through string formatting.
 //
 // The following types are encoded:
 //
@@ -46,7 +81,9 @@ func (e *MarshalerError) Error() string {
 // under the "ini" key in the struct field's tag.
 // The format string gives the name of the field, possibly followed by a
 // comma-separated list of options. The name may be empty in order to specify
-// options without overriding the default field name.
+// options without overriding the default field name. The default field name
+// is the name of the struct field, or the name derived from it by
+// Options.FieldNaming, such as SnakeCase, if set.
 //
 // The "omitempty" option specifies that the field should be omitted from the
 // encoding if the field is an empty value, defined as false, 0, a nil pointer,
@@ -54,6 +91,14 @@ func (e *MarshalerError) Error() string {
 //
 // As a special case, if the field tag is "-", the field is always omitted.
 //
+// The "default=" option gives the value of a property key that is absent when
+// decoding, as described by Unmarshal. When encoding with Options.OmitDefaults,
+// a field whose value equals its default is omitted from the encoding.
+//
+// The "layout=" option gives the layout, as accepted by time.Time.Format, in
+// which a time.Time field is encoded; the layout cannot contain a comma. The
+// "seconds" option encodes a time.Duration field as a plain number of seconds.
+//
 // Examples of struct field tags and their meanings:
 //
 //	// Field appears in INI as key "myName".
@@ -62,14 +107,25 @@ func (e *MarshalerError) Error() string {
 //	// Field is ignored by this package.
 //	Field int `ini:"-"`
 //
+//	// Field appears in INI as key "expires", in the form "2006-01-02".
+//	Field time.Time `ini:"expires,layout=2006-01-02"`
+//
+// time.Duration values encode in the format of time.Duration.String, such as
+// "1m30s". time.Time values encode in the RFC 3339 format, unless the field tag
+// gives a layout.
+//
 // Boolean values encode as the string literal "true" or "false".
 //
 // Floating point, integer and Number values encoded as string representations.
 //
-// String values encode as valid UTF-8 strings.
+// String values encode as valid UTF-8 strings. A value that would otherwise be
+// decoded differently, such as one that begins or ends with whitespace, begins
+// with a quote, or contains a newline or a comment character, is enclosed in
+// double quotes, with its quotes, backslashes and control characters escaped.
 //
-// Pointer values encode as the value pointed to. A nil pointer encodes as an
-// empty property value.
+// Pointer values encode as the value pointed to, in the format of its type, such
+// as the layout of a *time.Time. A nil pointer encodes as an empty property
+// value, which is omitted unless encoding with Options.WriteEmptyValues.
 //
 // Interface values encode as the value contained in the interface. A nil interface
 // value encodes as an empty property value.
@@ -77,29 +133,158 @@ func (e *MarshalerError) Error() string {
 // Slices and arrays are encoded as a sequential list of properties with
 // duplicate keys.
 //
-// Structs are encoded as a string, the value of which is derived from the
-// encoding.TextMarshaler interface. A struct that does not implement this
-// interface causes Marshal to return a MarshalTypeError.
+// A struct field of a section that is itself a struct, or a pointer to one,
+// is encoded as a section nested below it, named by the section name, a dot,
+// and the field name, such as [server.tls]; sections nest to any depth. A nil
+// pointer to a struct is not encoded. Other structs are encoded as a string,
+// the value of which is derived from the encoding.TextMarshaler interface.
+//
+// A map of structs with string keys is encoded as a section for each element,
+// named by the field name and the map key as a quoted subsection, such as
+// [remote "origin"], in sorted key order. The sections nested below an element
+// follow the closing quote, such as [remote "origin".tls].
 //
-// Channel, complex, map and function values cannot be encoded in INI.
-// Attempting to encode such a value causes Marshal to return a
-// MarshalTypeError.
+// Map values are encoded as a sequential list of properties, assigning each
+// value to the subkey named by its map key, in sorted key order.
+//
+// Channel, complex and function values cannot be encoded in INI. Attempting
+// to encode such a value causes Marshal to return a MarshalTypeError.
 func Marshal(v interface{}) ([]byte, error) {
+	return MarshalWithOptions(v, Options{})
+}
+
+// MarshalWithOptions allows encoding behavior to be configured with an Options
+// value.
+func MarshalWithOptions(v interface{}, opts Options) ([]byte, error) {
 	var buf bytes.Buffer
 
-	if err := encode(&buf, reflect.ValueOf(v)); err != nil {
+	e := NewEncoder(&buf)
+	e.SetOptions(opts)
+	if err := e.Encode(v); err != nil {
 		return nil, err
 	}
 	return bytes.TrimSpace(buf.Bytes()), nil
 }
 
+// An Encoder writes INI-encoded data to an output stream.
+//
+// In addition to encoding Go values with Encode, an Encoder can write a
+// document a line at a time with WriteSection, WriteKey, WriteSubkey and
+// WriteComment, so that a large document can be written without holding it in
+// memory. Each line is written to the output stream as it is encoded; the
+// first error returned by the output stream is returned by every subsequent
+// call.
+type Encoder struct {
+	w     io.Writer
+	opts  Options
+	buf   []byte // the line being encoded
+	wrote bool   // whether any line has been written
+	err   error
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// SetOptions allows encoding behavior to be configured with an Options value.
+// The options apply to every subsequent call to a method of e.
+func (e *Encoder) SetOptions(opts Options) {
+	e.opts = opts
+}
+
+// Encode writes the INI encoding of v to the stream.
+//
+// See the documentation for Marshal for details about the conversion of Go
+// values to INI.
+func (e *Encoder) Encode(v interface{}) error {
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return err
+	}
+	return e.err
+}
+
+// WriteSection writes a header for the section with the given name. Keys
+// written after the header belong to the section.
+func (e *Encoder) WriteSection(name string) error {
+	if e.wrote && e.opts.BlankLines == BlankLineBetweenSections {
+		e.buf = append(e.buf, e.opts.newline()...)
+	}
+	e.buf = append(e.buf, sectionStart)
+	e.buf = append(e.buf, name...)
+	e.buf = append(e.buf, sectionEnd)
+	return e.writeLine()
+}
+
+// WriteKey writes an assignment of value to key.
+func (e *Encoder) WriteKey(key, value string) error {
+	return e.WriteSubkey(key, "", value)
+}
+
+// WriteSubkey writes an assignment of value to the given subkey of key. If
+// subkey is empty, the value is assigned to key itself. The value is quoted if
+// it would otherwise be read back as a different value.
+func (e *Encoder) WriteSubkey(key, subkey, value string) error {
+	e.buf = append(e.buf, key...)
+	if subkey != "" {
+		e.buf = append(e.buf, mapKeyStart)
+		e.buf = append(e.buf, subkey...)
+		e.buf = append(e.buf, mapKeyEnd)
+	}
+	if e.opts.SpaceAroundAssignment {
+		e.buf = append(e.buf, space, assignment, space)
+	} else {
+		e.buf = append(e.buf, assignment)
+	}
+	e.buf = append(e.buf, quoteValue(value)...)
+	return e.writeLine()
+}
+
+// WriteComment writes text as a comment, beginning each line of text with the
+// comment character.
+func (e *Encoder) WriteComment(text string) error {
+	for _, line := range strings.Split(text, "\n") {
+		e.buf = append(e.buf, string(e.opts.commentChar())...)
+		if line != "" {
+			e.buf = append(e.buf, space)
+			e.buf = append(e.buf, line...)
+		}
+		if err := e.writeLine(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLine writes the line being encoded to the output stream, followed by a
+// line ending.
+func (e *Encoder) writeLine() error {
+	e.buf = append(e.buf, e.opts.newline()...)
+	if e.err == nil {
+		_, e.err = e.w.Write(e.buf)
+		e.wrote = true
+	}
+	e.buf = e.buf[:0]
+	return e.err
+}
+
+// writeProperties writes every property of s, in order.
+func (e *Encoder) writeProperties(s *section) error {
+	for _, p := range s.props {
+		if err := e.WriteSubkey(p.key, p.subkey, p.val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // encode reflects on the values of rv, encoding them as INI data. If rv is not
 // a pointer to a struct, an error is returned. encode makes two passes over
 // the struct fields of rv. The first pass skips struct fields that are
 // themselves structs, encoding all struct fields as "global" INI properties.
 // The second pass then encodes each struct field that *is* a struct as an
 // INI section.
-func encode(buf *bytes.Buffer, rv reflect.Value) error {
+func (e *Encoder) encode(rv reflect.Value) error {
 	if rv.Type().Kind() == reflect.Ptr {
 		rv = reflect.Indirect(rv)
 	}
@@ -112,9 +297,9 @@ func encode(buf *bytes.Buffer, rv reflect.Value) error {
 	for i := 0; i < rv.NumField(); i++ {
 		sf := rv.Type().Field(i)
 		sv := rv.Field(i)
-		t := newTag(sf)
+		t := newTagWithOptions(sf, e.opts)
 
-		if t.name == "-" || sf.Type.Kind() == reflect.Struct {
+		if t.name == "-" || encodesAsSection(sf.Type) || encodesAsSectionMap(sf.Type) {
 			continue
 		}
 
@@ -122,20 +307,22 @@ func encode(buf *bytes.Buffer, rv reflect.Value) error {
 			continue
 		}
 
-		if err := encodeProperty(buf, t.name, sv); err != nil {
+		if e.opts.OmitDefaults && isDefault(sf, t, sv) {
+			continue
+		}
+
+		if err := e.encodeProperty(t, "", sv); err != nil {
 			return err
 		}
 	}
 
-	buf.WriteRune('\n')
-
 	// second pass, only structs
 	for i := 0; i < rv.NumField(); i++ {
 		sf := rv.Type().Field(i)
 		sv := rv.Field(i)
-		t := newTag(sf)
+		t := newTagWithOptions(sf, e.opts)
 
-		if t.name == "-" || sf.Type.Kind() != reflect.Struct {
+		if t.name == "-" || !encodesAsSection(sf.Type) && !encodesAsSectionMap(sf.Type) {
 			continue
 		}
 
@@ -143,7 +330,13 @@ func encode(buf *bytes.Buffer, rv reflect.Value) error {
 			continue
 		}
 
-		if err := encodeSection(buf, t.name, sv); err != nil {
+		if encodesAsSectionMap(sf.Type) {
+			if err := e.encodeSectionMap(t.name, sv); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := e.encodeSection(t.name, sv); err != nil {
 			return err
 		}
 	}
@@ -151,19 +344,120 @@ func encode(buf *bytes.Buffer, rv reflect.Value) error {
 	return nil
 }
 
-func encodeSection(buf *bytes.Buffer, key string, rv reflect.Value) error {
+var (
+	marshalerType     = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	keyMarshalerType  = reflect.TypeOf((*KeyMarshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// encodesAsSection reports whether values of type t are encoded as a
+// section, rather than as a property value. A pointer to a struct is encoded
+// as the struct it points to.
+func encodesAsSection(t reflect.Type) bool {
+	if t.Implements(marshalerType) {
+		return true
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && !t.Implements(keyMarshalerType) && !t.Implements(textMarshalerType)
+}
+
+// encodesAsSectionMap reports whether values of type t are maps encoded as a
+// section for each element, named by its map key as a quoted subsection.
+func encodesAsSectionMap(t reflect.Type) bool {
+	return t.Kind() == reflect.Map && t.Key().Kind() == reflect.String && encodesAsSection(t.Elem()) && !encodesAsSection(t)
+}
+
+// isDefault reports whether rv, the value of the struct field sf, equals the
+// default value given by the "default=" option of its tag t.
+func isDefault(sf reflect.StructField, t tag, rv reflect.Value) bool {
+	if !t.hasDefault {
+		return false
+	}
+	dv := reflect.New(sf.Type)
+	if err := decodeField(defaultSection(t, sf.Type), sf, t, dv); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(dv.Elem().Interface(), rv.Interface())
+}
+
+// sectionName returns the name of the section child nested below the section
+// named parent, either dotted or, if quoted is true, as a quoted subsection.
+// A section nested below a quoted subsection follows the closing quote, such
+// as [remote "origin".tls] below [remote "origin"], so that the quoted name
+// remains a single map key.
+func sectionName(parent, child string, quoted bool) string {
+	if quoted {
+		return parent + ` "` + child + `"`
+	}
+	return parent + "." + child
+}
+
+// encodeSectionMap writes each element of the map rv as a section nested below
+// the section named key, such as [remote "origin"] for the map key "origin",
+// in sorted key order. If key is a pattern, the map key replaces its asterisk
+// instead, such as [program:web] for the pattern "program:*"; a pattern with
+// more than one asterisk cannot be encoded.
+func (e *Encoder) encodeSectionMap(key string, rv reflect.Value) error {
+	if strings.Count(key, "*") > 1 {
+		return &MarshalTypeError{typ: rv.Type()}
+	}
+	keys := rv.MapKeys()
+	slices.SortFunc(keys, func(a, b reflect.Value) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	for _, k := range keys {
+		name := sectionName(key, k.String(), true)
+		if strings.Contains(key, "*") {
+			name = strings.Replace(key, "*", k.String(), 1)
+		}
+		if err := e.encodeSection(name, rv.MapIndex(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeSection writes rv as the section named key, followed by each of its
+// struct fields that are themselves encoded as a section, nested below it as
+// the section named key, a dot, and the name of the field. A nil pointer is
+// not encoded.
+func (e *Encoder) encodeSection(key string, rv reflect.Value) error {
+	if rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil
+	}
+	if m, ok := rv.Interface().(Marshaler); ok {
+		s := newSection(key)
+		err := m.MarshalINI((*Section)(s))
+		if err == nil {
+			err = s.err
+		}
+		if err != nil {
+			return &MarshalerError{Type: rv.Type(), Err: err, sourceFunc: "MarshalINI"}
+		}
+		if err := e.WriteSection(s.name); err != nil {
+			return err
+		}
+		return e.writeProperties(s)
+	}
+
+	rv = reflect.Indirect(rv)
 	if rv.Type().Kind() != reflect.Struct {
 		return &MarshalTypeError{typ: rv.Type()}
 	}
 
-	buf.WriteString("[" + key + "]\n")
+	if err := e.WriteSection(key); err != nil {
+		return err
+	}
 
+	// first pass, skipping nested sections
 	for i := 0; i < rv.NumField(); i++ {
 		sf := rv.Type().Field(i)
 		sv := rv.Field(i)
-		t := newTag(sf)
+		t := newTagWithOptions(sf, e.opts)
 
-		if t.name == "-" {
+		if t.name == "-" || encodesAsSection(sf.Type) || encodesAsSectionMap(sf.Type) {
 			continue
 		}
 
@@ -171,24 +465,83 @@ func encodeSection(buf *bytes.Buffer, key string, rv reflect.Value) error {
 			continue
 		}
 
-		if err := encodeProperty(buf, t.name, sv); err != nil {
+		if e.opts.OmitDefaults && isDefault(sf, t, sv) {
+			continue
+		}
+
+		if err := e.encodeProperty(t, "", sv); err != nil {
 			return err
 		}
 	}
 
-	buf.WriteRune('\n')
+	// second pass, only nested sections
+	for i := 0; i < rv.NumField(); i++ {
+		sf := rv.Type().Field(i)
+		sv := rv.Field(i)
+		t := newTagWithOptions(sf, e.opts)
+
+		if t.name == "-" || !encodesAsSection(sf.Type) && !encodesAsSectionMap(sf.Type) {
+			continue
+		}
+
+		if t.omitempty && sv.Interface() == reflect.Zero(sv.Type()).Interface() {
+			continue
+		}
+
+		if encodesAsSectionMap(sf.Type) {
+			if err := e.encodeSectionMap(sectionName(key, t.name, false), sv); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := e.encodeSection(sectionName(key, t.name, false), sv); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
-// encodeProperty reflects on the concrete type of rv and encodes it as bytes
-// into buf. If rv implements the encoding.TextMarshaler interface, it is used
-// to encode the value, otherwise the type is encoded as a string using conversion
-// where possible.
-func encodeProperty(buf *bytes.Buffer, key string, rv reflect.Value) error {
+// encodeProperty reflects on the concrete type of rv and writes it as the
+// value of the subkey of the key named by t. Durations and times are encoded
+// according to the options of t. If rv implements the encoding.TextMarshaler
+// interface, it is used to encode the value, otherwise the type is encoded as
+// a string using conversion where possible.
+func (e *Encoder) encodeProperty(t tag, subkey string, rv reflect.Value) error {
 	var data []byte
 
-	if m, ok := rv.Interface().(encoding.TextMarshaler); ok {
+	// A nil pointer or interface value is encoded as an empty value.
+	if (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface) && rv.IsNil() {
+		if e.opts.WriteEmptyValues {
+			return e.WriteSubkey(t.name, subkey, "")
+		}
+		return nil
+	}
+
+	if m, ok := rv.Interface().(KeyMarshaler); ok && subkey == "" {
+		s := newSection("")
+		err := m.MarshalINIKey(&Key{s: s, name: t.name})
+		if err == nil {
+			err = s.err
+		}
+		if err != nil {
+			return &MarshalerError{Type: rv.Type(), Err: err, sourceFunc: "MarshalINIKey"}
+		}
+		return e.writeProperties(s)
+	}
+
+	// Look through pointers, unless the pointer itself marshals as text, so
+	// that the value pointed to is encoded in its own format, such as the
+	// layout of a *time.Time.
+	if rv.Kind() == reflect.Interface || rv.Kind() == reflect.Ptr && (rv.Type().Elem() == durationType || rv.Type().Elem() == timeType || !rv.Type().Implements(textMarshalerType)) {
+		return e.encodeProperty(t, subkey, rv.Elem())
+	}
+
+	if rv.Type() == durationType {
+		data = []byte(encodeDuration(time.Duration(rv.Int()), t.seconds))
+	} else if rv.Type() == timeType {
+		data = []byte(encodeTime(rv.Interface().(time.Time), t.layout))
+	} else if m, ok := rv.Interface().(encoding.TextMarshaler); ok {
 		var err error
 		data, err = m.MarshalText()
 		if err != nil {
@@ -198,20 +551,22 @@ func encodeProperty(buf *bytes.Buffer, key string, rv reflect.Value) error {
 		switch rv.Type().Kind() {
 		case reflect.Slice:
 			for i := 0; i < rv.Len(); i++ {
-				if err := encodeProperty(buf, key, rv.Index(i)); err != nil {
+				if err := e.encodeProperty(t, subkey, rv.Index(i)); err != nil {
 					return err
 				}
 			}
+			return nil
 		case reflect.Map:
-			iter := rv.MapRange()
-			for iter.Next() {
-				k := iter.Key()
-				v := iter.Value()
-				subkey := key + "[" + k.String() + "]"
-				if err := encodeProperty(buf, subkey, v); err != nil {
+			keys := rv.MapKeys()
+			slices.SortFunc(keys, func(a, b reflect.Value) int {
+				return strings.Compare(a.String(), b.String())
+			})
+			for _, k := range keys {
+				if err := e.encodeProperty(t, k.String(), rv.MapIndex(k)); err != nil {
 					return err
 				}
 			}
+			return nil
 		case reflect.String:
 			data = []byte(rv.String())
 		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
@@ -227,11 +582,26 @@ func encodeProperty(buf *bytes.Buffer, key string, rv reflect.Value) error {
 		}
 
 	}
-	if len(data) > 0 {
-		buf.WriteString(key)
-		buf.WriteRune('=')
-		buf.Write(data)
-		buf.WriteRune('\n')
+	if len(data) > 0 || e.opts.WriteEmptyValues {
+		return e.WriteSubkey(t.name, subkey, string(data))
 	}
 	return nil
 }
+
+// encodeDuration returns the encoding of d, in the format of
+// time.Duration.String, or as a plain number of seconds if seconds is true.
+func encodeDuration(d time.Duration, seconds bool) string {
+	if seconds {
+		return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+	}
+	return d.String()
+}
+
+// encodeTime returns the encoding of tm according to layout, or time.RFC3339
+// if layout is empty.
+func encodeTime(tm time.Time, layout string) string {
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	return tm.Format(layout)
+}
@@ -2,8 +2,11 @@ package ini
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -18,6 +21,37 @@ func (p point) MarshalText() ([]byte, error) {
 	return []byte(fmt.Sprintf("(%v,%v)", p.x, p.y)), nil
 }
 
+// environment is a section that assigns any number of values to any key.
+type environment map[string][]string
+
+func (e environment) MarshalINI(s *Section) error {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range e[k] {
+			s.AddValue(k, v)
+		}
+	}
+	return nil
+}
+
+// version is a key whose major and minor numbers are assigned to subkeys.
+type version struct {
+	major, minor int
+}
+
+func (v version) MarshalINIKey(k *Key) error {
+	if v.major < 0 || v.minor < 0 {
+		return fmt.Errorf("invalid version %v.%v", v.major, v.minor)
+	}
+	k.SetSubkeyValue("major", strconv.Itoa(v.major))
+	k.SetSubkeyValue("minor", strconv.Itoa(v.minor))
+	return nil
+}
+
 func TestEncodeProperty(t *testing.T) {
 	tests := []struct {
 		desc  string
@@ -128,7 +162,7 @@ func TestEncodeProperty(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.desc, func(t *testing.T) {
 			got := new(bytes.Buffer)
-			err := encodeProperty(got, test.input.key, reflect.ValueOf(test.input.val))
+			err := NewEncoder(got).encodeProperty(tag{name: test.input.key}, "", reflect.ValueOf(test.input.val))
 
 			if test.shouldError {
 				if !cmp.Equal(err, test.wantError, cmpopts.IgnoreUnexported(MarshalTypeError{})) {
@@ -166,7 +200,7 @@ func TestEncodeSection(t *testing.T) {
 			}{"s", struct {
 				P string `ini:"-"`
 			}{"v"}},
-			want: bytes.NewBufferString("[s]\n\n"),
+			want: bytes.NewBufferString("[s]\n"),
 		},
 		{
 			desc: "omitempty omits zero value",
@@ -177,7 +211,7 @@ func TestEncodeSection(t *testing.T) {
 				Z int `ini:",omitempty"`
 				N int `ini:"N"`
 			}{0, 1}},
-			want: bytes.NewBufferString("[s]\nN=1\n\n"),
+			want: bytes.NewBufferString("[s]\nN=1\n"),
 		},
 		{
 			desc: "encode error non-struct",
@@ -194,17 +228,17 @@ func TestEncodeSection(t *testing.T) {
 			input: struct {
 				key string
 				val interface{}
-			}{"s", struct{ P struct{} }{}},
+			}{"s", struct{ P complex128 }{}},
 			want:        nil,
 			shouldError: true,
-			wantError:   &MarshalTypeError{reflect.TypeOf(struct{}{})},
+			wantError:   &MarshalTypeError{reflect.TypeOf(complex128(0))},
 		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.desc, func(t *testing.T) {
 			got := new(bytes.Buffer)
-			err := encodeSection(got, test.input.key, reflect.ValueOf(test.input.val))
+			err := NewEncoder(got).encodeSection(test.input.key, reflect.ValueOf(test.input.val))
 
 			if test.shouldError {
 				if !cmp.Equal(err, test.wantError, cmpopts.IgnoreUnexported(MarshalTypeError{})) {
@@ -244,7 +278,7 @@ func TestEncode(t *testing.T) {
 				Z int `ini:",omitempty"`
 				N int
 			}{0, 1},
-			want: bytes.NewBufferString("N=1\n\n"),
+			want: bytes.NewBufferString("N=1\n"),
 		},
 		{
 			desc:        "encode error property",
@@ -258,7 +292,7 @@ func TestEncode(t *testing.T) {
 			input: func() interface{} {
 				return &struct{}{}
 			}(),
-			want: bytes.NewBufferString("\n"),
+			want: new(bytes.Buffer),
 		},
 		{
 			desc: "omitempty omits zero-value struct field",
@@ -266,21 +300,21 @@ func TestEncode(t *testing.T) {
 				Z struct{} `ini:",omitempty"`
 				N struct{}
 			}{struct{}{}, struct{}{}},
-			want: bytes.NewBufferString("\n[N]\n\n"),
+			want: bytes.NewBufferString("[N]\n"),
 		},
 		{
 			desc:        "encode error section property",
-			input:       struct{ S struct{ P struct{} } }{},
+			input:       struct{ S struct{ P complex128 } }{},
 			want:        nil,
 			shouldError: true,
-			wantError:   &MarshalTypeError{reflect.TypeOf(struct{}{})},
+			wantError:   &MarshalTypeError{reflect.TypeOf(complex128(0))},
 		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.desc, func(t *testing.T) {
 			got := new(bytes.Buffer)
-			err := encode(got, reflect.ValueOf(test.input))
+			err := NewEncoder(got).encode(reflect.ValueOf(test.input))
 
 			if test.shouldError {
 				if !cmp.Equal(err, test.wantError, cmpopts.IgnoreUnexported(MarshalTypeError{})) {
@@ -309,10 +343,10 @@ func TestMarshal(t *testing.T) {
 	}{
 		{
 			desc:        "marshal error",
-			input:       struct{ S struct{ P struct{} } }{},
+			input:       struct{ S struct{ P complex128 } }{},
 			want:        nil,
 			shouldError: true,
-			wantError:   &MarshalTypeError{reflect.TypeOf(struct{}{})},
+			wantError:   &MarshalTypeError{reflect.TypeOf(complex128(0))},
 		},
 	}
 
@@ -335,3 +369,467 @@ func TestMarshal(t *testing.T) {
 		})
 	}
 }
+
+func TestMarshalWithOptions(t *testing.T) {
+	type user struct {
+		Name  string            `ini:"name"`
+		Home  string            `ini:"home"`
+		Shell map[string]string `ini:"shell"`
+	}
+	input := struct {
+		Version string `ini:"version"`
+		Root    user   `ini:"root"`
+		Admin   user   `ini:"admin"`
+	}{
+		Version: "1",
+		Root:    user{Name: "root", Shell: map[string]string{"win32": "PowerShell.exe", "unix": "/bin/bash"}},
+		Admin:   user{Name: "admin", Home: "/home/admin"},
+	}
+
+	tests := []struct {
+		desc string
+		opts Options
+		want string
+	}{
+		{
+			desc: "default",
+			want: "version=1\n\n[root]\nname=root\nshell[unix]=/bin/bash\nshell[win32]=PowerShell.exe\n\n[admin]\nname=admin\nhome=/home/admin",
+		},
+		{
+			desc: "space around assignment",
+			opts: Options{SpaceAroundAssignment: true},
+			want: "version = 1\n\n[root]\nname = root\nshell[unix] = /bin/bash\nshell[win32] = PowerShell.exe\n\n[admin]\nname = admin\nhome = /home/admin",
+		},
+		{
+			desc: "no blank lines",
+			opts: Options{BlankLines: NoBlankLines},
+			want: "version=1\n[root]\nname=root\nshell[unix]=/bin/bash\nshell[win32]=PowerShell.exe\n[admin]\nname=admin\nhome=/home/admin",
+		},
+		{
+			desc: "CRLF",
+			opts: Options{CRLF: true},
+			want: "version=1\r\n\r\n[root]\r\nname=root\r\nshell[unix]=/bin/bash\r\nshell[win32]=PowerShell.exe\r\n\r\n[admin]\r\nname=admin\r\nhome=/home/admin",
+		},
+		{
+			desc: "write empty values",
+			opts: Options{WriteEmptyValues: true},
+			want: "version=1\n\n[root]\nname=root\nhome=\"\"\nshell[unix]=/bin/bash\nshell[win32]=PowerShell.exe\n\n[admin]\nname=admin\nhome=/home/admin",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := MarshalWithOptions(input, test.opts)
+			if err != nil {
+				t.Fatalf("MarshalWithOptions() returned %v, want nil", err)
+			}
+			if string(got) != test.want {
+				t.Errorf("MarshalWithOptions() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	tests := []struct {
+		desc  string
+		opts  Options
+		write func(e *Encoder)
+		want  string
+	}{
+		{
+			desc: "lines",
+			write: func(e *Encoder) {
+				e.WriteComment("generated\n\ndo not edit")
+				e.WriteKey("version", "1")
+				e.WriteSection("user")
+				e.WriteKey("name", "root")
+				e.WriteSubkey("shell", "unix", "/bin/bash")
+				e.WriteSection("group")
+			},
+			want: "; generated\n;\n; do not edit\nversion=1\n\n[user]\nname=root\nshell[unix]=/bin/bash\n\n[group]\n",
+		},
+		{
+			desc: "comment char",
+			opts: Options{CommentChar: '#', CRLF: true},
+			write: func(e *Encoder) {
+				e.WriteSection("user")
+				e.WriteComment("UNIX user name")
+				e.WriteKey("name", "root")
+			},
+			want: "[user]\r\n# UNIX user name\r\nname=root\r\n",
+		},
+		{
+			desc: "encode after lines",
+			write: func(e *Encoder) {
+				e.WriteComment("generated")
+				e.Encode(struct {
+					User struct {
+						Name string `ini:"name"`
+					} `ini:"user"`
+				}{})
+			},
+			want: "; generated\n\n[user]\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var got bytes.Buffer
+			e := NewEncoder(&got)
+			e.SetOptions(test.opts)
+			test.write(e)
+
+			if got.String() != test.want {
+				t.Errorf("Encoder wrote %q, want %q", got.String(), test.want)
+			}
+		})
+	}
+}
+
+func TestEncoderError(t *testing.T) {
+	want := errors.New("broken pipe")
+	e := NewEncoder(errWriter{want})
+
+	if err := e.WriteSection("user"); err != want {
+		t.Errorf("WriteSection() returned %v, want %v", err, want)
+	}
+	if err := e.WriteKey("name", "root"); err != want {
+		t.Errorf("WriteKey() returned %v, want %v", err, want)
+	}
+	if err := e.Encode(struct{ Name string }{"root"}); err != want {
+		t.Errorf("Encode() returned %v, want %v", err, want)
+	}
+}
+
+type errWriter struct {
+	err error
+}
+
+func (w errWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
+
+func TestMarshalINI(t *testing.T) {
+	tests := []struct {
+		desc      string
+		input     interface{}
+		opts      Options
+		want      string
+		wantError string
+	}{
+		{
+			desc: "marshalers",
+			input: struct {
+				Version version     `ini:"version"`
+				Env     environment `ini:"env"`
+			}{
+				Version: version{1, 2},
+				Env:     environment{"PATH": {"/bin", "/usr/bin"}, "HOME": {"/root"}},
+			},
+			opts: Options{SpaceAroundAssignment: true},
+			want: "version[major] = 1\nversion[minor] = 2\n\n[env]\nHOME = /root\nPATH = /bin\nPATH = /usr/bin",
+		},
+		{
+			desc: "key marshaler in section",
+			input: struct {
+				App struct {
+					Name    string  `ini:"name"`
+					Version version `ini:"version"`
+				} `ini:"app"`
+			}{},
+			want: "[app]\nversion[major]=0\nversion[minor]=0",
+		},
+		{
+			desc: "key marshaler error",
+			input: struct {
+				Version version `ini:"version"`
+			}{Version: version{-1, 0}},
+			wantError: "ini: error calling MarshalINIKey for type ini.version: invalid version -1.0",
+		},
+		{
+			desc: "marshaler invalid key",
+			input: struct {
+				Env environment `ini:"env"`
+			}{Env: environment{"PATH=/bin": {"/usr/bin"}}},
+			wantError: `ini: error calling MarshalINI for type ini.environment: ini: invalid key name: "PATH=/bin"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := MarshalWithOptions(test.input, test.opts)
+			if test.wantError != "" {
+				if err == nil || err.Error() != test.wantError {
+					t.Fatalf("MarshalWithOptions(%#v) returned %v, want %v", test.input, err, test.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MarshalWithOptions(%#v) returned %v, want nil", test.input, err)
+			}
+			if string(got) != test.want {
+				t.Errorf("MarshalWithOptions(%#v) = %q, want %q", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMarshalNested(t *testing.T) {
+	type ca struct {
+		File string `ini:"file"`
+	}
+	type tls struct {
+		Cert string `ini:"cert"`
+		CA   *ca    `ini:"ca"`
+	}
+	type server struct {
+		TLS  tls    `ini:"tls"`
+		Addr string `ini:"addr"`
+	}
+	type config struct {
+		Server server `ini:"server"`
+	}
+
+	tests := []struct {
+		desc  string
+		input config
+		want  string
+	}{
+		{
+			desc:  "nested",
+			input: config{Server: server{Addr: ":443", TLS: tls{Cert: "server.pem", CA: &ca{File: "ca.pem"}}}},
+			want:  "[server]\naddr=:443\n\n[server.tls]\ncert=server.pem\n\n[server.tls.ca]\nfile=ca.pem",
+		},
+		{
+			desc:  "nil pointer",
+			input: config{Server: server{Addr: ":443", TLS: tls{Cert: "server.pem"}}},
+			want:  "[server]\naddr=:443\n\n[server.tls]\ncert=server.pem",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := Marshal(test.input)
+			if err != nil {
+				t.Fatalf("Marshal(%#v) returned %v, want nil", test.input, err)
+			}
+			if string(got) != test.want {
+				t.Errorf("Marshal(%#v) = %q, want %q", test.input, got, test.want)
+			}
+
+			var rt config
+			if err := Unmarshal(got, &rt); err != nil {
+				t.Fatalf("Unmarshal(%q) returned %v, want nil", got, err)
+			}
+			if !cmp.Equal(rt, test.input) {
+				t.Errorf("Unmarshal(%q) = %v, want %v", got, rt, test.input)
+			}
+		})
+	}
+}
+
+func TestMarshalSectionMap(t *testing.T) {
+	type tls struct {
+		Cert string `ini:"cert"`
+	}
+	type remote struct {
+		URL string `ini:"url"`
+		TLS *tls   `ini:"tls"`
+	}
+	type config struct {
+		Name    string            `ini:"name"`
+		Remotes map[string]remote `ini:"remote"`
+	}
+
+	input := config{
+		Name: "repo",
+		Remotes: map[string]remote{
+			"upstream": {URL: "https://example.com/upstream.git"},
+			"origin":   {URL: "https://example.com/origin.git", TLS: &tls{Cert: "origin.pem"}},
+			"v1":       {URL: "v1.git"},
+			"v1.2":     {URL: "v1.2.git", TLS: &tls{Cert: "v1.2.pem"}},
+		},
+	}
+	want := "name=repo\n\n[remote \"origin\"]\nurl=https://example.com/origin.git\n\n[remote \"origin\".tls]\ncert=origin.pem\n\n[remote \"upstream\"]\nurl=https://example.com/upstream.git\n\n[remote \"v1\"]\nurl=v1.git\n\n[remote \"v1.2\"]\nurl=v1.2.git\n\n[remote \"v1.2\".tls]\ncert=v1.2.pem"
+
+	got, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal(%#v) returned %v, want nil", input, err)
+	}
+	if string(got) != want {
+		t.Errorf("Marshal(%#v) = %q, want %q", input, got, want)
+	}
+
+	var rt config
+	if err := UnmarshalWithOptions(got, &rt, Options{DisallowUnknownSections: true}); err != nil {
+		t.Fatalf("UnmarshalWithOptions(%q) returned %v, want nil", got, err)
+	}
+	if !cmp.Equal(rt, input) {
+		t.Errorf("UnmarshalWithOptions(%q) = %v, want %v\ndiff -want +got\n%v", got, rt, input, cmp.Diff(input, rt))
+	}
+}
+
+func TestMarshalOmitDefaults(t *testing.T) {
+	type database struct {
+		Host    string            `ini:"host,default=localhost"`
+		Port    int               `ini:"port,default=5432"`
+		Replica []string          `ini:"replica,default=db1,db2"`
+		Options map[string]string `ini:"option,default=sslmode=disable"`
+		User    string            `ini:"user"`
+	}
+	type config struct {
+		Debug    bool     `ini:"debug,default=true"`
+		Database database `ini:"database"`
+	}
+
+	tests := []struct {
+		desc  string
+		input config
+		opts  Options
+		want  string
+	}{
+		{
+			desc: "defaults written",
+			input: config{
+				Debug:    true,
+				Database: database{Host: "localhost", Port: 5432, Replica: []string{"db1", "db2"}, Options: map[string]string{"sslmode": "disable"}, User: "app"},
+			},
+			want: "debug=true\n\n[database]\nhost=localhost\nport=5432\nreplica=db1\nreplica=db2\noption[sslmode]=disable\nuser=app",
+		},
+		{
+			desc: "defaults omitted",
+			input: config{
+				Debug:    true,
+				Database: database{Host: "localhost", Port: 5432, Replica: []string{"db1", "db2"}, Options: map[string]string{"sslmode": "disable"}, User: "app"},
+			},
+			opts: Options{OmitDefaults: true},
+			want: "[database]\nuser=app",
+		},
+		{
+			desc: "changes written",
+			input: config{
+				Database: database{Host: "db", Port: 5432, Replica: []string{"db1"}, Options: map[string]string{"sslmode": "disable"}},
+			},
+			opts: Options{OmitDefaults: true},
+			want: "debug=false\n\n[database]\nhost=db\nreplica=db1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := MarshalWithOptions(test.input, test.opts)
+			if err != nil {
+				t.Fatalf("MarshalWithOptions(%#v) returned %v, want nil", test.input, err)
+			}
+			if string(got) != test.want {
+				t.Errorf("MarshalWithOptions(%#v) = %q, want %q", test.input, got, test.want)
+			}
+
+			var rt config
+			if err := Unmarshal(got, &rt); err != nil {
+				t.Fatalf("Unmarshal(%q) returned %v, want nil", got, err)
+			}
+			if !cmp.Equal(rt, test.input) {
+				t.Errorf("Unmarshal(%q) = %v, want %v\ndiff -want +got\n%v", got, rt, test.input, cmp.Diff(test.input, rt))
+			}
+		})
+	}
+}
+
+func TestMarshalNaming(t *testing.T) {
+	type server struct {
+		HostName   string
+		MaxRetries int
+		LogLevel   string `ini:"verbosity"`
+	}
+	type config struct {
+		DryRun bool
+		Server server
+	}
+
+	input := config{DryRun: true, Server: server{HostName: "db", MaxRetries: 3, LogLevel: "debug"}}
+	tests := []struct {
+		desc string
+		opts Options
+		want string
+	}{
+		{
+			desc: "field names",
+			want: "DryRun=true\n\n[Server]\nHostName=db\nMaxRetries=3\nverbosity=debug",
+		},
+		{
+			desc: "snake case",
+			opts: Options{FieldNaming: SnakeCase},
+			want: "dry_run=true\n\n[server]\nhost_name=db\nmax_retries=3\nverbosity=debug",
+		},
+		{
+			desc: "kebab case",
+			opts: Options{FieldNaming: KebabCase},
+			want: "dry-run=true\n\n[server]\nhost-name=db\nmax-retries=3\nverbosity=debug",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := MarshalWithOptions(input, test.opts)
+			if err != nil {
+				t.Fatalf("MarshalWithOptions(%#v) returned %v, want nil", input, err)
+			}
+			if string(got) != test.want {
+				t.Errorf("MarshalWithOptions(%#v) = %q, want %q", input, got, test.want)
+			}
+
+			var rt config
+			if err := UnmarshalWithOptions(got, &rt, test.opts); err != nil {
+				t.Fatalf("UnmarshalWithOptions(%q) returned %v, want nil", got, err)
+			}
+			if !cmp.Equal(rt, input) {
+				t.Errorf("UnmarshalWithOptions(%q) = %v, want %v", got, rt, input)
+			}
+		})
+	}
+}
+
+func TestMarshalQuoted(t *testing.T) {
+	type config struct {
+		Prompt  string   `ini:"prompt"`
+		Comment string   `ini:"comment"`
+		Motd    string   `ini:"motd"`
+		Path    string   `ini:"path"`
+		Plain   string   `ini:"plain"`
+		Quoted  []string `ini:"quoted"`
+	}
+
+	input := config{
+		Prompt:  "$ ",
+		Comment: "a;b",
+		Motd:    "Welcome\n\tto the \"system\"",
+		Path:    `C:\`,
+		Plain:   `say "hi"`,
+		Quoted:  []string{`"a"`, "'b'"},
+	}
+	want := `prompt="$ "
+comment="a;b"
+motd="Welcome\n\tto the \"system\""
+path="C:\\"
+plain=say "hi"
+quoted="\"a\""
+quoted="'b'"`
+
+	got, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal(%#v) returned %v, want nil", input, err)
+	}
+	if string(got) != want {
+		t.Errorf("Marshal(%#v) = %q, want %q", input, got, want)
+	}
+
+	var rt config
+	if err := Unmarshal(got, &rt); err != nil {
+		t.Fatalf("Unmarshal(%q) returned %v, want nil", got, err)
+	}
+	if !cmp.Equal(rt, input) {
+		t.Errorf("Unmarshal(%q) = %v, want %v\ndiff -want +got\n%v", got, rt, input, cmp.Diff(input, rt))
+	}
+}
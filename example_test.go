@@ -3,6 +3,7 @@ package ini_test
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/subpop/go-ini"
 )
@@ -59,6 +60,25 @@ func ExampleMarshal() {
 	// Path[unix]=/var/db
 }
 
+func ExampleEncoder() {
+	e := ini.NewEncoder(os.Stdout)
+	e.SetOptions(ini.Options{SpaceAroundAssignment: true})
+
+	_ = e.WriteComment("Generated file; do not edit.")
+	for _, name := range []string{"auth", "cache"} {
+		_ = e.WriteSection(name)
+		_ = e.WriteKey("enabled", "true")
+	}
+	// Output:
+	// ; Generated file; do not edit.
+	//
+	// [auth]
+	// enabled = true
+	//
+	// [cache]
+	// enabled = true
+}
+
 func ExampleUnmarshal() {
 	type Database struct {
 		Server string
@@ -100,3 +120,41 @@ func ExampleUnmarshal() {
 	// Output:
 	// {1.2.3 {John Doe Acme Widgets Inc.} {192.0.2.62 143 payroll.dat map[unix:/var/db win32:C:\db]}}
 }
+
+func ExampleScanner() {
+	s := ini.NewScanner(strings.NewReader("; database\n[server]\nport=143\n"))
+	for s.Scan() {
+		tok := s.Token()
+		fmt.Printf("%v\t%v\t%q\n", tok.Pos, tok.Type, tok.Val)
+	}
+	if err := s.Err(); err != nil {
+		fmt.Println("error:", err)
+	}
+	// Output:
+	// 1:1	comment	"; database"
+	// 2:1	section	"server"
+	// 3:1	key	"port"
+	// 3:5	assignment	"="
+	// 3:6	value	"143"
+}
+
+func ExampleParse() {
+	data := []byte(`[plugin]
+name=auth
+option[timeout]=30
+
+[plugin]
+name=cache
+option[size]=512`)
+
+	f, err := ini.Parse(data)
+	if err != nil {
+		fmt.Println("error:", err)
+	}
+	for _, s := range f.SectionsNamed("plugin") {
+		fmt.Println(s.Key("name").Value(), s.Key("option").Subkeys())
+	}
+	// Output:
+	// auth [timeout]
+	// cache [size]
+}
@@ -0,0 +1,367 @@
+package ini
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// An InvalidNameError describes a section name, property key or subkey that
+// cannot be written so that it parses back as the same name, such as an empty
+// key or one containing an assignment character. An edit of a File using such
+// a name is not made, and File.WriteTo returns the error instead of writing
+// the File.
+type InvalidNameError struct {
+	Kind string // "section", "key" or "subkey"
+	Name string // the invalid name
+}
+
+func (e *InvalidNameError) Error() string {
+	return "ini: invalid " + e.Kind + " name: " + strconv.Quote(e.Name)
+}
+
+// checkName returns an *InvalidNameError if name cannot be written as a name
+// of the given kind: if it is empty, other than an empty subkey, contains a
+// bracket or a line ending, or is a key containing an assignment character or
+// beginning with a comment character.
+func checkName(kind, name string) error {
+	invalid := strings.ContainsAny(name, "[]\r\n")
+	switch kind {
+	case "section":
+		invalid = invalid || name == ""
+	case "key":
+		invalid = invalid || name == "" || strings.ContainsRune(name, assignment) || strings.IndexAny(name, ";#") == 0
+	}
+	if invalid {
+		return &InvalidNameError{Kind: kind, Name: name}
+	}
+	return nil
+}
+
+// A File represents a parsed INI document. Unlike Unmarshal, which requires a
+// destination struct declared ahead of time, a File allows the sections and
+// property keys of a document to be inspected when its shape is not known in
+// advance.
+//
+// A File retains the source text it was parsed from, including comments, blank
+// lines and whitespace, so that it can be edited and written back without
+// disturbing anything but the edited values.
+type File struct {
+	tree parseTree
+}
+
+// Parse parses the INI-encoded data and returns the resulting File. If the data
+// is malformed, Parse returns a *SyntaxError.
+func Parse(data []byte) (*File, error) {
+	return parse("", data, Options{})
+}
+
+// ParseWithOptions allows parsing behavior to be configured with an Options
+// value.
+func ParseWithOptions(data []byte, opts Options) (*File, error) {
+	return parse("", data, opts)
+}
+
+// ParseFile reads the named file and parses its contents, configuring parsing
+// behavior with opts. Syntax errors refer to the file by name.
+func ParseFile(filename string, opts Options) (*File, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parse(filename, data, opts)
+}
+
+func parse(filename string, data []byte, opts Options) (*File, error) {
+	p := newParser(data)
+	p.l.name = filename
+	p.l.opts = opts.lexerOptions()
+	if err := p.parse(); err != nil {
+		if opts.ContinueOnError {
+			return &File{tree: p.tree}, err
+		}
+		return nil, err
+	}
+
+	return &File{tree: p.tree}, nil
+}
+
+// WriteTo writes the INI encoding of f to w. An unmodified File is written
+// byte-for-byte as it was parsed. If an edit of f was rejected for an invalid
+// name, WriteTo writes nothing and returns the first such *InvalidNameError.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	if err := f.tree.err(); err != nil {
+		return 0, err
+	}
+	var buf bytes.Buffer
+	f.tree.write(&buf)
+	return buf.WriteTo(w)
+}
+
+// SetValue sets the first value assigned to key in the first section with the
+// given name. The section and key are added to the end of the file if they do
+// not exist. An empty section name refers to the global section.
+func (f *File) SetValue(section, key, value string) {
+	s := f.Global()
+	if section != "" {
+		s = f.Section(section)
+		if s == nil {
+			s = f.AddSection(section)
+		}
+	}
+	s.SetValue(key, value)
+}
+
+// AddSection adds a new, empty section with the given name to the end of the
+// file and returns it. If name is not a valid section name, such as one
+// containing a bracket, WriteTo reports an *InvalidNameError.
+func (f *File) AddSection(name string) *Section {
+	nl := f.tree.newline()
+	s := newSection(name)
+	s.raw.trailing = nl
+	if err := checkName("section", name); err != nil {
+		s.fail(err)
+	}
+
+	var buf bytes.Buffer
+	f.tree.write(&buf)
+	if buf.Len() > 0 {
+		f.tree.terminate()
+		s.raw.leading = f.tree.trailing
+		if !bytes.HasSuffix(buf.Bytes(), []byte(nl+nl)) {
+			s.raw.leading += nl
+		}
+		f.tree.trailing = ""
+	}
+	f.tree.add(s)
+
+	return (*Section)(s)
+}
+
+// RemoveSection removes s, along with the comments directly preceding it, from
+// the file. Comments separated from its header by a blank line, such as a file
+// header comment, are kept. It reports whether s was found; the global section
+// cannot be removed.
+func (f *File) RemoveSection(s *Section) bool {
+	return f.tree.remove((*section)(s))
+}
+
+// Global returns the section containing the property keys that appear before
+// the first section header.
+func (f *File) Global() *Section {
+	return (*Section)(f.tree.global)
+}
+
+// Sections returns every named section in the file, including each occurrence
+// of a repeated section name, in the order they appear.
+func (f *File) Sections() []*Section {
+	sections := make([]*Section, 0, len(f.tree.sections))
+	for _, s := range f.tree.sections {
+		sections = append(sections, (*Section)(s))
+	}
+	return sections
+}
+
+// Section returns the first section with the given name, or nil if no such
+// section exists.
+func (f *File) Section(name string) *Section {
+	sections := f.SectionsNamed(name)
+	if len(sections) == 0 {
+		return nil
+	}
+	return sections[0]
+}
+
+// SectionsNamed returns every occurrence of the section with the given name,
+// in the order they appear.
+func (f *File) SectionsNamed(name string) []*Section {
+	sections := make([]*Section, 0)
+	for _, s := range f.tree.sections {
+		if s.name == name {
+			sections = append(sections, (*Section)(s))
+		}
+	}
+	return sections
+}
+
+// A Section represents a single section of a File, from its header up to the
+// next section header.
+type Section section
+
+// Name returns the name of the section. The global section has an empty name.
+func (s *Section) Name() string {
+	return s.name
+}
+
+// Keys returns every property key of the section, in the order each first
+// appears.
+func (s *Section) Keys() []*Key {
+	names := (*section)(s).keys()
+	keys := make([]*Key, 0, len(names))
+	for _, name := range names {
+		keys = append(keys, &Key{s: (*section)(s), name: name})
+	}
+	return keys
+}
+
+// SetName renames the section, rewriting its header. The global section
+// cannot be renamed. An invalid name is reported by File.WriteTo.
+func (s *Section) SetName(name string) {
+	(*section)(s).setName(name)
+}
+
+// SetValue sets the first value assigned to the property key with the given
+// name, adding the key to the end of the section if it does not exist.
+func (s *Section) SetValue(key, value string) {
+	k := Key{s: (*section)(s), name: key}
+	k.SetValue(value)
+}
+
+// AddValue adds an assignment of value to the property key with the given
+// name after its last assignment, adding the key to the end of the section if
+// it does not exist.
+func (s *Section) AddValue(key, value string) {
+	k := Key{s: (*section)(s), name: key}
+	k.AddValue(value)
+}
+
+// SetSubkeyValue sets the first value assigned to the given subkey of the
+// property key with the given name, adding the assignment after the last
+// assignment of the key, or to the end of the section, if it does not exist.
+func (s *Section) SetSubkeyValue(key, subkey, value string) {
+	k := Key{s: (*section)(s), name: key}
+	k.SetSubkeyValue(subkey, value)
+}
+
+// DeleteKey removes every assignment of the property key with the given name,
+// along with the comments preceding each of them. Comments separated from an
+// assignment by a blank line, such as a file header, are kept.
+func (s *Section) DeleteKey(name string) {
+	(*section)(s).remove(name)
+}
+
+// Key returns the property key with the given name, or nil if no such key
+// exists in the section.
+func (s *Section) Key(name string) *Key {
+	if !(*section)(s).has(name) {
+		return nil
+	}
+	return &Key{s: (*section)(s), name: name}
+}
+
+// A Key represents a property key of a Section, along with every value
+// assigned to it.
+type Key struct {
+	s    *section
+	name string
+}
+
+// Name returns the name of the property key.
+func (k *Key) Name() string {
+	return k.name
+}
+
+// Value returns the first value assigned to the key, or an empty string if the
+// key only has values assigned to subkeys.
+func (k *Key) Value() string {
+	vals := k.Values()
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
+
+// Values returns every value assigned to the key, in the order they appear. A
+// key that appears more than once in a section has more than one value.
+func (k *Key) Values() []string {
+	return k.SubkeyValues("")
+}
+
+// Subkeys returns the names of the subkeys of the key, in the order each first
+// appears. For example, the property "shell[unix]=/bin/bash" assigns a value
+// to the subkey "unix" of the key "shell".
+func (k *Key) Subkeys() []string {
+	return k.s.subkeys(k.name)
+}
+
+// SetValue sets the first value assigned to the key, leaving the surrounding
+// source text unchanged. If the key has no such value, a new assignment is
+// added after the last assignment of the key.
+func (k *Key) SetValue(value string) {
+	k.SetSubkeyValue("", value)
+}
+
+// AddValue adds an assignment of value to the key after its last assignment,
+// giving the key a duplicate value.
+func (k *Key) AddValue(value string) {
+	if err := checkName("key", k.name); err != nil {
+		k.s.fail(err)
+		return
+	}
+	k.s.insert(k.s.end(k.name), newProperty(k.name, "", value, k.s.newline()))
+}
+
+// SetSubkeyValue sets the first value assigned to the given subkey, leaving the
+// surrounding source text unchanged. If the subkey does not exist, a new
+// assignment is added after the last assignment of the key.
+func (k *Key) SetSubkeyValue(subkey, value string) {
+	err := checkName("key", k.name)
+	if err == nil {
+		err = checkName("subkey", subkey)
+	}
+	if err != nil {
+		k.s.fail(err)
+		return
+	}
+	for i := range k.s.props {
+		p := &k.s.props[i]
+		if p.key == k.name && p.subkey == subkey {
+			p.setValue(value)
+			return
+		}
+	}
+	k.s.insert(k.s.end(k.name), newProperty(k.name, subkey, value, k.s.newline()))
+}
+
+// SetName renames the key, rewriting each of its assignments. An invalid name
+// is reported by File.WriteTo.
+func (k *Key) SetName(name string) {
+	if err := checkName("key", name); err != nil {
+		k.s.fail(err)
+		return
+	}
+	for i := range k.s.props {
+		if k.s.props[i].key == k.name {
+			k.s.props[i].key = name
+		}
+	}
+	k.name = name
+}
+
+// MoveTo moves every assignment of the key, along with the comments preceding
+// each of them, to the end of dst. Comments separated from an assignment by a
+// blank line, such as a file header, are kept in place.
+func (k *Key) MoveTo(dst *Section) {
+	for _, p := range k.s.remove(k.name) {
+		(*section)(dst).insert(len(dst.props), p)
+	}
+	k.s = (*section)(dst)
+}
+
+// SubkeyValue returns the first value assigned to the given subkey, or an
+// empty string if the subkey does not exist.
+func (k *Key) SubkeyValue(subkey string) string {
+	vals := k.SubkeyValues(subkey)
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
+
+// SubkeyValues returns every value assigned to the given subkey, in the order
+// they appear.
+func (k *Key) SubkeyValues(subkey string) []string {
+	return k.s.get(k.name, subkey)
+}
@@ -0,0 +1,655 @@
+package ini
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFileKey(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		section     string
+		key         string
+		subkey      string
+		want        []string
+	}{
+		{
+			description: "global key",
+			input:       "version=1\n[user]\nname=root",
+			key:         "version",
+			want:        []string{"1"},
+		},
+		{
+			description: "section key",
+			input:       "version=1\n[user]\nname=root",
+			section:     "user",
+			key:         "name",
+			want:        []string{"root"},
+		},
+		{
+			description: "duplicate key",
+			input:       "[user]\ngroup=wheel\ngroup=video",
+			section:     "user",
+			key:         "group",
+			want:        []string{"wheel", "video"},
+		},
+		{
+			description: "subkey",
+			input:       "[user]\nshell[unix]=/bin/bash\nshell[win32]=PowerShell.exe",
+			section:     "user",
+			key:         "shell",
+			subkey:      "win32",
+			want:        []string{"PowerShell.exe"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			f, err := Parse([]byte(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sec := f.Global()
+			if test.section != "" {
+				sec = f.Section(test.section)
+			}
+			if sec == nil {
+				t.Fatalf("Section(%v) = nil", test.section)
+			}
+			key := sec.Key(test.key)
+			if key == nil {
+				t.Fatalf("Key(%v) = nil", test.key)
+			}
+			got := key.SubkeyValues(test.subkey)
+
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("%v != %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFileSections(t *testing.T) {
+	f, err := Parse([]byte("[user]\nname=root\n[user]\nname=admin\n[group]\nname=wheel"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]string, 0)
+	for _, s := range f.Sections() {
+		got = append(got, s.Name())
+	}
+	want := []string{"user", "user", "group"}
+	if !cmp.Equal(got, want) {
+		t.Errorf("%v != %v", got, want)
+	}
+
+	got = make([]string, 0)
+	for _, s := range f.SectionsNamed("user") {
+		got = append(got, s.Key("name").Value())
+	}
+	want = []string{"root", "admin"}
+	if !cmp.Equal(got, want) {
+		t.Errorf("%v != %v", got, want)
+	}
+
+	if s := f.Section("missing"); s != nil {
+		t.Errorf("Section(%v) = %v, want nil", "missing", s)
+	}
+	if k := f.Section("group").Key("missing"); k != nil {
+		t.Errorf("Key(%v) = %v, want nil", "missing", k)
+	}
+}
+
+func TestKeySubkeys(t *testing.T) {
+	f, err := Parse([]byte("shell=/bin/sh\nshell[unix]=/bin/bash\nshell[win32]=PowerShell.exe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := f.Global().Key("shell")
+	got := key.Subkeys()
+	want := []string{"unix", "win32"}
+
+	if !cmp.Equal(got, want) {
+		t.Errorf("%v != %v", got, want)
+	}
+	if got := key.Value(); got != "/bin/sh" {
+		t.Errorf("%v != %v", got, "/bin/sh")
+	}
+	if got := key.SubkeyValue("unix"); got != "/bin/bash" {
+		t.Errorf("%v != %v", got, "/bin/bash")
+	}
+}
+
+func TestFileWriteTo(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		opts        Options
+	}{
+		{
+			description: "comments and blank lines",
+			input:       "; global\nversion=1\n\n; users\n[user]\n; name\nname=root\n\n\n[group] \nname=wheel\n; trailing comment\n",
+		},
+		{
+			description: "spacing",
+			input:       "  version=1\n[ user ]\n\tshell[unix]=/bin/bash\n   \n",
+		},
+		{
+			description: "crlf line endings",
+			input:       "version=1\r\n[user]\r\nname=root\r\n",
+		},
+		{
+			description: "no final line ending",
+			input:       "[user]\nname=root",
+		},
+		{
+			description: "multiline values",
+			input:       "[user]\nbio=line one\\\nline two\ngroups=wheel\n  video\nname=root\n",
+			opts:        Options{AllowMultilineValues: true},
+		},
+		{
+			description: "number sign comments",
+			input:       "# global\n[user]\n# name\nname=root\n",
+			opts:        Options{AllowNumberSignComments: true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			f, err := ParseWithOptions([]byte(test.input), test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if _, err := f.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := buf.String(); got != test.input {
+				t.Errorf("WriteTo() = %q, want %q", got, test.input)
+			}
+		})
+	}
+}
+
+func TestKeySetValue(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		section     string
+		key         string
+		value       string
+		want        string
+	}{
+		{
+			description: "replace value",
+			input:       "; users\n[user]\n; name\nname=root\nshell=/bin/sh\n",
+			section:     "user",
+			key:         "name",
+			value:       "admin",
+			want:        "; users\n[user]\n; name\nname=admin\nshell=/bin/sh\n",
+		},
+		{
+			description: "replace first duplicate",
+			input:       "group=wheel\r\ngroup=video\r\n",
+			key:         "group",
+			value:       "audio",
+			want:        "group=audio\r\ngroup=video\r\n",
+		},
+		{
+			description: "add after subkeys",
+			input:       "[user]\r\nshell[unix]=/bin/bash\r\nname=root",
+			section:     "user",
+			key:         "shell",
+			value:       "/bin/sh",
+			want:        "[user]\r\nshell[unix]=/bin/bash\r\nshell=/bin/sh\r\nname=root",
+		},
+		{
+			description: "add at end of input",
+			input:       "[user]\nshell[unix]=/bin/bash",
+			section:     "user",
+			key:         "shell",
+			value:       "/bin/sh",
+			want:        "[user]\nshell[unix]=/bin/bash\nshell=/bin/sh\n",
+		},
+		{
+			description: "replace value surrounded by whitespace",
+			input:       "[ user ]\nname = root ; comment\nshell [ unix ] =\t/bin/bash  \n",
+			section:     "user",
+			key:         "shell",
+			value:       "/bin/sh",
+			want:        "[ user ]\nname = root ; comment\nshell [ unix ] =\t/bin/bash  \nshell=/bin/sh\n",
+		},
+		{
+			description: "replace quoted value",
+			input:       "[user]\nname = \"root\"\n",
+			section:     "user",
+			key:         "name",
+			value:       " admin ",
+			want:        "[user]\nname = \" admin \"\n",
+		},
+		{
+			description: "replace with empty value",
+			input:       "[user]\nname=root\n",
+			section:     "user",
+			key:         "name",
+			value:       "",
+			want:        "[user]\nname=\"\"\n",
+		},
+		{
+			description: "replace value with whitespace around assignment",
+			input:       "[ user ]\nname = root  \nshell=/bin/bash\n",
+			section:     "user",
+			key:         "name",
+			value:       "admin",
+			want:        "[ user ]\nname = admin  \nshell=/bin/bash\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			f, err := Parse([]byte(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sec := f.Global()
+			if test.section != "" {
+				sec = f.Section(test.section)
+			}
+			sec.Key(test.key).SetValue(test.value)
+
+			var buf bytes.Buffer
+			if _, err := f.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := buf.String(); got != test.want {
+				t.Errorf("WriteTo() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFileEdit(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		edit        func(f *File)
+		want        string
+	}{
+		{
+			description: "set value of new key",
+			input:       "[user]\nname=root\n\n[group]\nname=wheel\n",
+			edit: func(f *File) {
+				f.SetValue("user", "shell", "/bin/bash")
+			},
+			want: "[user]\nname=root\nshell=/bin/bash\n\n[group]\nname=wheel\n",
+		},
+		{
+			description: "set value of new section",
+			input:       "version=1\n; end of file",
+			edit: func(f *File) {
+				f.SetValue("user", "name", "root")
+			},
+			want: "version=1\n; end of file\n\n[user]\nname=root\n",
+		},
+		{
+			description: "set global value",
+			input:       "; header\n\n[user]\nname=root\n",
+			edit: func(f *File) {
+				f.SetValue("", "version", "1")
+			},
+			want: "version=1\n; header\n\n[user]\nname=root\n",
+		},
+		{
+			description: "add section to empty file",
+			input:       "",
+			edit: func(f *File) {
+				f.AddSection("user").SetValue("name", "root")
+			},
+			want: "[user]\nname=root\n",
+		},
+		{
+			description: "add section with crlf line endings",
+			input:       "version=1\r\n\r\n",
+			edit: func(f *File) {
+				f.AddSection("user").SetValue("name", "root")
+			},
+			want: "version=1\r\n\r\n[user]\r\nname=root\r\n",
+		},
+		{
+			description: "add duplicate value",
+			input:       "[user]\ngroup=wheel\nname=root\n",
+			edit: func(f *File) {
+				f.Section("user").Key("group").AddValue("video")
+			},
+			want: "[user]\ngroup=wheel\ngroup=video\nname=root\n",
+		},
+		{
+			description: "set subkey value",
+			input:       "[user]\nshell[unix]=/bin/sh\nname=root\n",
+			edit: func(f *File) {
+				k := f.Section("user").Key("shell")
+				k.SetSubkeyValue("unix", "/bin/bash")
+				k.SetSubkeyValue("win32", "PowerShell.exe")
+			},
+			want: "[user]\nshell[unix]=/bin/bash\nshell[win32]=PowerShell.exe\nname=root\n",
+		},
+		{
+			description: "add values and subkey values to section",
+			input:       "[user]\nname=root\n",
+			edit: func(f *File) {
+				s := f.Section("user")
+				s.AddValue("group", "wheel")
+				s.AddValue("group", "video")
+				s.SetSubkeyValue("shell", "unix", "/bin/bash")
+			},
+			want: "[user]\nname=root\ngroup=wheel\ngroup=video\nshell[unix]=/bin/bash\n",
+		},
+		{
+			description: "delete key",
+			input:       "[user]\n; login name\nname=root\n; groups\ngroup=wheel\ngroup=video\nshell=/bin/bash\n",
+			edit: func(f *File) {
+				f.Section("user").DeleteKey("group")
+			},
+			want: "[user]\n; login name\nname=root\nshell=/bin/bash\n",
+		},
+		{
+			description: "delete first global key",
+			input:       "; Copyright\n\nname=foo\nother=bar\n",
+			edit: func(f *File) {
+				f.Global().DeleteKey("name")
+			},
+			want: "; Copyright\n\nother=bar\n",
+		},
+		{
+			description: "delete only global key",
+			input:       "; Copyright\n\n; name\nname=foo\n\n[user]\nname=root\n",
+			edit: func(f *File) {
+				f.Global().DeleteKey("name")
+			},
+			want: "; Copyright\n\n\n[user]\nname=root\n",
+		},
+		{
+			description: "delete last key of section",
+			input:       "[user]\nname=root\n\n; shell\n\nshell=/bin/sh\n",
+			edit: func(f *File) {
+				f.Section("user").DeleteKey("shell")
+			},
+			want: "[user]\nname=root\n\n; shell\n\n",
+		},
+		{
+			description: "move key with detached comment",
+			input:       "; Copyright\n\nname=foo\nother=bar\n\n[user]\n",
+			edit: func(f *File) {
+				f.Global().Key("name").MoveTo(f.Section("user"))
+			},
+			want: "; Copyright\n\nother=bar\n\n[user]\nname=foo\n",
+		},
+		{
+			description: "rename key",
+			input:       "[user]\ngroup=wheel ; admins\ngroup=video\n",
+			edit: func(f *File) {
+				f.Section("user").Key("group").SetName("groups")
+			},
+			want: "[user]\ngroups=wheel ; admins\ngroups=video\n",
+		},
+		{
+			description: "rename section",
+			input:       "; users\n[user]\nname=root\n",
+			edit: func(f *File) {
+				f.Section("user").SetName("account")
+			},
+			want: "; users\n[account]\nname=root\n",
+		},
+		{
+			description: "remove section",
+			input:       "[user]\nname=root\n\n; groups\n[group]\nname=wheel\n\n[host]\nname=localhost\n",
+			edit: func(f *File) {
+				f.RemoveSection(f.Section("group"))
+			},
+			want: "[user]\nname=root\n\n[host]\nname=localhost\n",
+		},
+		{
+			description: "remove first section after header comment",
+			input:       "; top\n\n; users\n[user]\nname=root\n[group]\nname=wheel\n",
+			edit: func(f *File) {
+				f.RemoveSection(f.Section("user"))
+			},
+			want: "; top\n\n[group]\nname=wheel\n",
+		},
+		{
+			description: "remove first section before blank line",
+			input:       "; top\r\n\r\n[user]\r\nname=root\r\n\r\n[group]\r\nname=wheel\r\n",
+			edit: func(f *File) {
+				f.RemoveSection(f.Section("user"))
+			},
+			want: "; top\r\n\r\n[group]\r\nname=wheel\r\n",
+		},
+		{
+			description: "remove only section after header comment",
+			input:       "; top\n\n[user]\nname=root\n",
+			edit: func(f *File) {
+				f.RemoveSection(f.Section("user"))
+			},
+			want: "; top\n\n",
+		},
+		{
+			description: "move key",
+			input:       "[user]\nname=root\n; shell\nshell=/bin/bash\n[defaults]\numask=022",
+			edit: func(f *File) {
+				f.Section("user").Key("shell").MoveTo(f.Section("defaults"))
+			},
+			want: "[user]\nname=root\n[defaults]\numask=022\n; shell\nshell=/bin/bash\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			f, err := Parse([]byte(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			test.edit(f)
+
+			var buf bytes.Buffer
+			if _, err := f.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := buf.String(); got != test.want {
+				t.Errorf("WriteTo() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFileEditInvalidName(t *testing.T) {
+	tests := []struct {
+		description string
+		edit        func(f *File)
+		want        *InvalidNameError
+	}{
+		{
+			description: "add section with bracket",
+			edit:        func(f *File) { f.AddSection("x]y") },
+			want:        &InvalidNameError{Kind: "section", Name: "x]y"},
+		},
+		{
+			description: "add empty section",
+			edit:        func(f *File) { f.AddSection("") },
+			want:        &InvalidNameError{Kind: "section", Name: ""},
+		},
+		{
+			description: "rename section with line ending",
+			edit:        func(f *File) { f.Section("user").SetName("a\nb") },
+			want:        &InvalidNameError{Kind: "section", Name: "a\nb"},
+		},
+		{
+			description: "set key with assignment",
+			edit:        func(f *File) { f.SetValue("", "k=v", "x") },
+			want:        &InvalidNameError{Kind: "key", Name: "k=v"},
+		},
+		{
+			description: "set empty key",
+			edit:        func(f *File) { f.SetValue("", "", "x") },
+			want:        &InvalidNameError{Kind: "key", Name: ""},
+		},
+		{
+			description: "add key beginning with comment",
+			edit:        func(f *File) { f.Section("user").AddValue("; name", "x") },
+			want:        &InvalidNameError{Kind: "key", Name: "; name"},
+		},
+		{
+			description: "set subkey with bracket",
+			edit:        func(f *File) { f.Section("user").SetSubkeyValue("shell", "[unix]", "x") },
+			want:        &InvalidNameError{Kind: "subkey", Name: "[unix]"},
+		},
+		{
+			description: "rename key with bracket",
+			edit:        func(f *File) { f.Section("user").Key("name").SetName("name[0]") },
+			want:        &InvalidNameError{Kind: "key", Name: "name[0]"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			f, err := Parse([]byte("[user]\nname=root\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			test.edit(f)
+
+			var buf bytes.Buffer
+			n, err := f.WriteTo(&buf)
+			var got *InvalidNameError
+			if !errors.As(err, &got) {
+				t.Fatalf("WriteTo() returned %v, want *InvalidNameError", err)
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("WriteTo() returned %v, want %v", got, test.want)
+			}
+			if n != 0 || buf.Len() != 0 {
+				t.Errorf("WriteTo() wrote %q, want nothing", buf.String())
+			}
+		})
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "config.ini")
+	if err := os.WriteFile(name, []byte("[user]\nname=root\nshell\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := ParseFile(name, Options{})
+	if err == nil {
+		t.Fatalf("ParseFile(%v) returned nil, want error", name)
+	}
+
+	want := name + `:3:6: unexpected character: '\n', a property key must be followed by the assignment character ('=')`
+	if got := err.Error(); got != want {
+		t.Errorf("%v != %v", got, want)
+	}
+}
+
+func TestParseContinueOnError(t *testing.T) {
+	input := "[user]\nname\nshell=/bin/bash\n[group\n"
+
+	f, err := ParseWithOptions([]byte(input), Options{ContinueOnError: true})
+	if err == nil {
+		t.Fatalf("ParseWithOptions(%q) returned nil, want error", input)
+	}
+
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("ParseWithOptions(%q) returned %v, want *SyntaxError", input, err)
+	}
+	if syntaxErr.Kind != MissingAssignment {
+		t.Errorf("%v != %v", syntaxErr.Kind, MissingAssignment)
+	}
+	if f == nil {
+		t.Fatalf("ParseWithOptions(%q) returned nil File", input)
+	}
+	if got := f.Section("user").Key("shell").Value(); got != "/bin/bash" {
+		t.Errorf("%v != %v", got, "/bin/bash")
+	}
+
+	if f, _ := Parse([]byte(input)); f != nil {
+		t.Errorf("Parse(%q) = %v, want nil", input, f)
+	}
+}
+
+func TestParseContinueOnErrorSections(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		want        map[string][]string // the keys of each section, by name
+		wantErrs    int
+	}{
+		{
+			description: "unclosed first section",
+			input:       "[a\nk=v\n",
+			want:        map[string][]string{"": {}},
+			wantErrs:    1,
+		},
+		{
+			description: "unclosed middle section",
+			input:       "top=1\n[a]\nx=1\n[b\nk=v\n; comment\nbroken\n[c]\ny=2\n",
+			want:        map[string][]string{"": {"top"}, "a": {"x"}, "c": {"y"}},
+			wantErrs:    2,
+		},
+		{
+			description: "unclosed sections in a row",
+			input:       "[a]\nx=1\n[b\nk=v\n[c\nl=w\n",
+			want:        map[string][]string{"": {}, "a": {"x"}},
+			wantErrs:    2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			f, err := ParseWithOptions([]byte(test.input), Options{ContinueOnError: true})
+			joined, ok := err.(interface{ Unwrap() []error })
+			if !ok {
+				t.Fatalf("ParseWithOptions(%q) returned %v, want joined errors", test.input, err)
+			}
+			if got := len(joined.Unwrap()); got != test.wantErrs {
+				t.Errorf("ParseWithOptions(%q) returned %v errors, want %v: %v", test.input, got, test.wantErrs, err)
+			}
+
+			got := make(map[string][]string)
+			for _, s := range append([]*Section{f.Global()}, f.Sections()...) {
+				keys := make([]string, 0)
+				for _, k := range s.Keys() {
+					keys = append(keys, k.Name())
+				}
+				got[s.Name()] = keys
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("ParseWithOptions(%q) = %v, want %v", test.input, got, test.want)
+			}
+
+			var buf bytes.Buffer
+			if _, err := f.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != test.input {
+				t.Errorf("WriteTo() = %q, want %q", buf.String(), test.input)
+			}
+		})
+	}
+}
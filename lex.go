@@ -1,34 +1,64 @@
 package ini
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf16"
 	"unicode/utf8"
 )
 
-// unexpectedCharErr describes an invalid rune given the lexer's current state.
-type unexpectedCharErr struct {
-	got rune
-	msg string
-}
-
-func (e unexpectedCharErr) Error() string {
-	return fmt.Sprintf("unexpected character: %q, %v", e.got, e.msg)
-}
-
-type tokenType int
+// A TokenType identifies the type of a lexical token of INI source text.
+type TokenType int
 
 const (
-	tokenError tokenType = iota
-	tokenPropKey
-	tokenMapKey
-	tokenAssignment
-	tokenPropValue
-	tokenSection
-	tokenComment
-	tokenEOF
+	// TokenError is a syntax error, described by the Err field of the Token.
+	TokenError TokenType = iota
+	// TokenKey is the key of a property, such as "shell" in
+	// "shell[unix]=/bin/bash".
+	TokenKey
+	// TokenSubkey is the subkey of a property, such as "[unix]" in
+	// "shell[unix]=/bin/bash".
+	TokenSubkey
+	// TokenAssignment is the assignment character ('=').
+	TokenAssignment
+	// TokenValue is the value of a property, such as "/bin/bash" in
+	// "shell[unix]=/bin/bash". A multiline value is a single token.
+	TokenValue
+	// TokenSection is a section header, such as "[user]".
+	TokenSection
+	// TokenComment is a comment, from its comment character to the end of
+	// the line.
+	TokenComment
+	// TokenEOF is the end of the input.
+	TokenEOF
 )
 
+func (t TokenType) String() string {
+	switch t {
+	case TokenError:
+		return "error"
+	case TokenKey:
+		return "key"
+	case TokenSubkey:
+		return "subkey"
+	case TokenAssignment:
+		return "assignment"
+	case TokenValue:
+		return "value"
+	case TokenSection:
+		return "section"
+	case TokenComment:
+		return "comment"
+	case TokenEOF:
+		return "EOF"
+	}
+	return "TokenType(" + strconv.Itoa(int(t)) + ")"
+}
+
 const (
 	eof          = rune(0)
 	comment      = ';'
@@ -42,13 +72,73 @@ const (
 	space        = ' '
 	tab          = '\t'
 	numberSign   = '#'
+	doubleQuote  = '"'
+	singleQuote  = '\''
+)
+
+// escapes maps the character following a backslash in a quoted value to the
+// character the escape sequence stands for. A "\u" escape, followed by four
+// hexadecimal digits, stands for the Unicode code point they give, which must
+// not be a surrogate.
+var escapes = map[rune]rune{
+	'n':         '\n',
+	't':         '\t',
+	'r':         '\r',
+	doubleQuote: doubleQuote,
+	singleQuote: singleQuote,
+	escape:      escape,
+}
+
+// The sets of bytes that end the scanning of a line in each state.
+const (
+	lineEnd      = string(eol)
+	sectionChars = string(sectionEnd) + lineEnd
+	propKeyChars = string(assignment) + string(mapKeyStart) + lineEnd
+	mapKeyChars  = string(mapKeyEnd) + lineEnd
 )
 
 type stateFunc func(l *lexer) stateFunc
 
+// A Position describes a location in INI source text.
+type Position struct {
+	Filename string // name of the file, if any
+	Offset   int    // byte offset, starting at 0
+	Line     int    // line number, starting at 1
+	Column   int    // column number in characters, starting at 1
+}
+
+// IsValid reports whether the position is valid.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns a string in one of several forms:
+//
+//	file:line:column    valid position with file name
+//	line:column         valid position without file name
+//	file                invalid position with file name
+//	-                   invalid position without file name
+func (p Position) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}
+
 type token struct {
-	typ tokenType
-	val string
+	typ  TokenType
+	val  string       // the value of the token, without any delimiters
+	raw  string       // the source text of the token, including any delimiters
+	lead string       // source text skipped between the previous token and this one
+	pos  Position     // the position of the first character of raw
+	err  *SyntaxError // the error described by a TokenError
 }
 
 type lexerOptions struct {
@@ -56,31 +146,89 @@ type lexerOptions struct {
 	allowMultilineWhitespacePrefix bool // support space-prefixed lines
 	allowEmptyValues               bool // accept empty values as valid
 	allowNumberSignComments        bool // treat lines beginning with the number sign (#) as a comment
+	continueOnError                bool // resume lexing at the next line after an error
+	keepWhitespace                 bool // retain whitespace around names and values
 }
 
 type lexer struct {
-	input  string // the string being scanned.
-	start  int    // start position of current token.
-	pos    int    // current position in the input.
-	width  int    // width of last rune read.
-	state  stateFunc
-	tokens chan token
-	opts   lexerOptions
+	name    string        // the name of the input, used in positions.
+	input   string        // the string being scanned.
+	r       *bufio.Reader // the source of further input, if any.
+	err     error         // the first error returned by r, other than io.EOF.
+	base    int           // offset of input within the source.
+	line    int           // number of line endings before scanned.
+	bol     int           // offset of the beginning of the line containing scanned.
+	scanned int           // offset up to which line endings have been counted.
+	end     int           // end position of the last emitted token.
+	start   int           // start position of current token.
+	pos     int           // current position in the input.
+	width   int           // width of last rune read.
+	state   stateFunc
+	tok     token // the last emitted token.
+	emitted bool  // whether tok has been emitted since the last call to nextToken.
+	opts    lexerOptions
 }
 
 func lex(input string) *lexer {
 	l := &lexer{
-		input:  input,
-		state:  lexLineStart,
-		tokens: make(chan token, 2),
+		input: input,
+		state: lexLineStart,
 	}
 	return l
 }
 
+// lexReader creates a lexer that reads its input from r a line at a time, as
+// the input is needed.
+func lexReader(r *bufio.Reader) *lexer {
+	l := lex("")
+	l.r = r
+	return l
+}
+
+// fill reads the next line from the reader of the lexer and appends it to the
+// input, reporting whether any input was read. Input that precedes both the
+// last emitted token and the line containing it is discarded first, so that
+// only the line being scanned is retained.
+func (l *lexer) fill() bool {
+	if l.r == nil {
+		return false
+	}
+	line, err := l.r.ReadString(eol)
+	if err != nil {
+		if err != io.EOF {
+			l.err = err
+		}
+		l.r = nil
+	}
+	if len(line) == 0 {
+		return false
+	}
+	n := min(l.end, l.bol)
+	l.input = l.input[n:] + line
+	l.base += n
+	l.bol -= n
+	l.scanned -= n
+	l.end -= n
+	l.start -= n
+	l.pos -= n
+	return true
+}
+
+// more reports whether at least n bytes of input follow the current position,
+// reading further input if necessary.
+func (l *lexer) more(n int) bool {
+	for l.pos+n > len(l.input) {
+		if !l.fill() {
+			return false
+		}
+	}
+	return true
+}
+
 // next returns the next rune in the input and advances the position of the lexer
 // ahead by the width of the rune.
 func (l *lexer) next() rune {
-	if l.pos >= len(l.input) {
+	if !l.more(1) {
 		l.width = 0
 		return eof
 	}
@@ -103,7 +251,7 @@ func (l *lexer) prev() rune {
 
 // peek returns the next rune from the input without advancing the position
 func (l *lexer) peek() rune {
-	if l.pos >= len(l.input) {
+	if !l.more(1) {
 		l.width = 0
 		return eof
 	}
@@ -125,11 +273,47 @@ func (l *lexer) current() string {
 	return l.input[l.start:l.pos]
 }
 
+// position returns the Position of the byte offset off within the input.
+// Offsets must be requested in non-decreasing order.
+func (l *lexer) position(off int) Position {
+	for {
+		i := strings.IndexByte(l.input[l.scanned:off], eol)
+		if i < 0 {
+			break
+		}
+		l.line++
+		l.scanned += i + 1
+		l.bol = l.scanned
+	}
+	l.scanned = off
+	return Position{
+		Filename: l.name,
+		Offset:   l.base + off,
+		Line:     l.line + 1,
+		Column:   utf8.RuneCountInString(l.input[l.bol:off]) + 1,
+	}
+}
+
 // emit emits a token of type t, resetting the start position of the lexer to
 // the current position.
-func (l *lexer) emit(t tokenType) {
-	l.tokens <- token{t, l.current()}
+func (l *lexer) emit(t TokenType) {
+	l.emitDelimited(t, 0)
+}
+
+// emitDelimited emits a token of type t whose value is surrounded by n
+// delimiting bytes on each side, such as the brackets of a section name.
+func (l *lexer) emitDelimited(t TokenType, n int) {
+	raw := l.current()
+	l.tok = token{
+		typ:  t,
+		val:  raw[n : len(raw)-n],
+		raw:  raw,
+		lead: l.input[l.end:l.start],
+		pos:  l.position(l.start),
+	}
+	l.emitted = true
 	l.start = l.pos
+	l.end = l.pos
 }
 
 // ignore resets the start position of the lexer to the current position, but
@@ -138,32 +322,147 @@ func (l *lexer) ignore() {
 	l.start = l.pos
 }
 
-// error returns an error in the form of a stateFunc.
-func (l *lexer) error(err error) stateFunc {
-	l.tokens <- token{
-		tokenError,
-		err.Error(),
+// skipSpace advances the position over any spaces and tabs and ignores them,
+// unless the lexer is configured to keep whitespace. The skipped whitespace
+// becomes part of the lead of the next token.
+func (l *lexer) skipSpace() {
+	if l.opts.keepWhitespace {
+		return
+	}
+	for r := l.peek(); r == space || r == tab; r = l.peek() {
+		l.next()
+	}
+	l.ignore()
+}
+
+// backupSpace moves the position back over any spaces and tabs that end the
+// current token, unless the lexer is configured to keep whitespace.
+func (l *lexer) backupSpace() {
+	if l.opts.keepWhitespace {
+		return
+	}
+	for l.pos > l.start && (l.input[l.pos-1] == space || l.input[l.pos-1] == tab) {
+		l.pos--
+	}
+}
+
+// trimSpace removes any leading and trailing whitespace from the value of the
+// last emitted token, unless the lexer is configured to keep whitespace.
+func (l *lexer) trimSpace() {
+	if !l.opts.keepWhitespace {
+		l.tok.val = strings.TrimSpace(l.tok.val)
+	}
+}
+
+// error returns an error in the form of a stateFunc. The error is reported at
+// the current position of the lexer. If the lexer is configured to continue
+// on error, the remainder of the line is skipped, becoming the source text of
+// the error token, and lexing resumes at the start of the next line.
+func (l *lexer) error(kind ErrorKind, text string, got rune, msg string) stateFunc {
+	pos := l.position(l.pos)
+	err := &SyntaxError{
+		Kind: kind,
+		Pos:  pos,
+		Text: text,
+		Msg:  fmt.Sprintf("unexpected character: %q, %v", got, msg),
+		line: l.lineAt(l.pos),
+	}
+	var state stateFunc
+	if l.opts.continueOnError {
+		l.scanLine(lineEnd)
+		state = lexLineStart
+	}
+	l.tok = token{
+		typ:  TokenError,
+		val:  err.Msg,
+		raw:  l.current(),
+		lead: l.input[l.end:l.start],
+		pos:  pos,
+		err:  err,
+	}
+	l.emitted = true
+	l.start = l.pos
+	l.end = l.pos
+	return state
+}
+
+// source returns the source text between the byte offsets start and end of
+// the source, reporting whether it is still held in the input.
+func (l *lexer) source(start, end int) (string, bool) {
+	if start < l.base || end > l.base+len(l.input) {
+		return "", false
 	}
-	return nil
+	return l.input[start-l.base : end-l.base], true
 }
 
-// nextToken receives the next token emitted by the lexer.
+// lineAt returns the line of input containing the byte offset off within the
+// input, without its line ending.
+func (l *lexer) lineAt(off int) string {
+	if off < 0 || off > len(l.input) {
+		return ""
+	}
+	start := strings.LastIndexByte(l.input[:off], eol) + 1
+	end := strings.IndexByte(l.input[off:], eol)
+	if end < 0 {
+		end = len(l.input)
+	} else {
+		end += off
+	}
+	return strings.TrimSuffix(l.input[start:end], "\r")
+}
+
+// eolWidth returns the width of the line ending at the current position, or 0
+// if the current position is not at the end of a line.
+func (l *lexer) eolWidth() int {
+	switch {
+	case strings.HasPrefix(l.input[l.pos:], "\r\n"):
+		return 2
+	case strings.HasPrefix(l.input[l.pos:], "\n"):
+		return 1
+	}
+	return 0
+}
+
+// scanLine advances the position to the first byte of the current line that
+// is one of chars, which must include the line feed character, and returns
+// that byte. If no such byte precedes the line ending, the position is
+// advanced to the end of the line and 0 is returned.
+func (l *lexer) scanLine(chars string) byte {
+	if !l.more(1) {
+		return 0
+	}
+	rest := l.input[l.pos:]
+	i := strings.IndexAny(rest, chars)
+	if i < 0 {
+		l.pos = len(l.input)
+		return 0
+	}
+	if rest[i] != eol {
+		l.pos += i
+		return rest[i]
+	}
+	if i > 0 && rest[i-1] == '\r' {
+		i--
+	}
+	l.pos += i
+	return 0
+}
+
+// nextToken runs the state machine of the lexer until it emits a token, and
+// returns the token.
 func (l *lexer) nextToken() token {
-	for {
-		select {
-		case token := <-l.tokens:
-			return token
-		default:
-			l.state = l.state(l)
-		}
+	l.emitted = false
+	for !l.emitted {
+		l.state = l.state(l)
 	}
+	return l.tok
 }
 
 func lexLineStart(l *lexer) stateFunc {
 	r := l.next()
 	switch {
 	case r == eof:
-		l.emit(tokenEOF)
+		l.emit(TokenEOF)
 		return lexLineStart
 	case r == comment:
 		return lexComment
@@ -171,7 +470,8 @@ func lexLineStart(l *lexer) stateFunc {
 		if l.opts.allowNumberSignComments {
 			return lexComment
 		}
-		return l.error(&unexpectedCharErr{r, "comments cannot begin with '#'; consider enabling Options.AllowNumberSignComments"})
+		l.prev()
+		return l.error(IllegalCharacter, string(r), r, "comments cannot begin with '#'; consider enabling Options.AllowNumberSignComments")
 	case r == sectionStart:
 		return lexSection
 	case unicode.IsSpace(r):
@@ -180,55 +480,35 @@ func lexLineStart(l *lexer) stateFunc {
 	case unicode.IsLetter(r) || unicode.IsDigit(r):
 		return lexPropKey
 	default:
-		return l.error(&unexpectedCharErr{r, "lines can only begin with '[', ';', or alphanumeric characters"})
+		l.prev()
+		return l.error(IllegalCharacter, string(r), r, "lines can only begin with '[', ';', or alphanumeric characters")
 	}
 }
 
 func lexComment(l *lexer) stateFunc {
-	var r rune
-	for {
-		r = l.peek()
-		if r == eol || r == eof {
-			break
-		}
-		l.next()
-	}
-	l.emit(tokenComment)
+	l.scanLine(lineEnd)
+	l.emit(TokenComment)
 	return lexLineStart
 }
 
 func lexSection(l *lexer) stateFunc {
-	var r rune
-	l.ignore()
-	for {
-		r = l.peek()
-		if r == eol || r == eof {
-			return l.error(&unexpectedCharErr{r, "sections must be closed with a ']'"})
-		}
-		if r == sectionEnd {
-			break
-		}
-		l.next()
+	if l.scanLine(sectionChars) != sectionEnd {
+		return l.error(UnclosedSection, l.current(), l.peek(), "sections must be closed with a ']'")
 	}
-	l.emit(tokenSection)
 	l.next()
-	l.ignore()
+	l.emitDelimited(TokenSection, 1)
+	l.trimSpace()
 	return lexLineStart
 }
 
 func lexPropKey(l *lexer) stateFunc {
-	var r rune
-	for {
-		r = l.peek()
-		if r == eol || r == eof {
-			return l.error(&unexpectedCharErr{r, "a property key must be followed by the assignment character ('=')"})
-		}
-		if r == assignment || r == mapKeyStart {
-			break
-		}
-		l.next()
+	r := l.scanLine(propKeyChars)
+	if r == 0 {
+		return l.error(MissingAssignment, l.current(), l.peek(), "a property key must be followed by the assignment character ('=')")
 	}
-	l.emit(tokenPropKey)
+	l.backupSpace()
+	l.emit(TokenKey)
+	l.skipSpace()
 	if r == mapKeyStart {
 		return lexMapKey
 	}
@@ -236,60 +516,113 @@ func lexPropKey(l *lexer) stateFunc {
 }
 
 func lexMapKey(l *lexer) stateFunc {
-	var r rune
 	l.next()
-	l.ignore()
-	for {
-		r = l.peek()
-		if r == eol || r == eof {
-			return l.error(&unexpectedCharErr{r, "subkeys must be closed with a ']'"})
-		}
-		if r == mapKeyEnd {
-			break
-		}
-		l.next()
+	if l.scanLine(mapKeyChars) != mapKeyEnd {
+		return l.error(UnclosedSubkey, l.current(), l.peek(), "subkeys must be closed with a ']'")
 	}
-	l.emit(tokenMapKey)
 	l.next()
-	l.ignore()
+	l.emitDelimited(TokenSubkey, 1)
+	l.trimSpace()
 	return lexAssignment
 }
 
 func lexAssignment(l *lexer) stateFunc {
-	r := l.next()
-	if r != assignment {
-		panic("lexer: invalid state encountered")
+	for r := l.peek(); r == space || r == tab; r = l.peek() {
+		l.next()
 	}
-	l.emit(tokenAssignment)
+	l.ignore()
+	if r := l.next(); r != assignment {
+		l.prev()
+		return l.error(MissingAssignment, l.current(), l.peek(), "a property key must be followed by the assignment character ('=')")
+	}
+	l.emit(TokenAssignment)
+	l.skipSpace()
 	return lexPropValue
 }
 
 func lexPropValue(l *lexer) stateFunc {
-	var r rune
-	for {
-		r = l.peek()
-		if r == eol || r == eof {
-			break
-		}
-		l.next()
+	if r := l.peek(); r == doubleQuote || r == singleQuote {
+		return lexQuotedValue
 	}
+	return lexRawValue
+}
+
+// lexRawValue lexes a value as written, up to the end of the line, or of the
+// last line it continues onto.
+func lexRawValue(l *lexer) stateFunc {
+	l.scanLine(lineEnd)
 	if !l.opts.allowEmptyValues && len(l.current()) == 0 {
-		l.error(&unexpectedCharErr{r, "an assignment must be followed by one or more alphanumeric characters"})
+		return l.error(EmptyValue, l.current(), l.peek(), "an assignment must be followed by one or more alphanumeric characters")
 	}
 	if l.opts.allowMultilineWhitespacePrefix {
-		l.next()
-		if unicode.IsSpace(l.peek()) {
-			return lexPropValue
+		if w := l.eolWidth(); w > 0 && l.more(w+1) {
+			r, _ := utf8.DecodeRuneInString(l.input[l.pos+w:])
+			if unicode.IsSpace(r) {
+				l.pos += w
+				return lexRawValue
+			}
 		}
-		l.prev()
 	}
 	if l.opts.allowMultilineEscapeNewline {
-		r := l.rpeek()
-		if r == escape {
-			l.next()
-			return lexPropValue
+		if w := l.eolWidth(); w > 0 && l.pos > l.start && rune(l.input[l.pos-1]) == escape {
+			l.pos += w
+			return lexRawValue
 		}
 	}
-	l.emit(tokenPropValue)
+	l.backupSpace()
+	l.emit(TokenValue)
 	return lexLineStart
 }
+
+// lexQuotedValue lexes a value enclosed in single or double quotes, in which
+// escape sequences are replaced by the characters they stand for. The value of
+// the emitted token is the unquoted value, and its source text includes the
+// quotes. If anything but whitespace follows the closing quote on the same
+// line, as in `"/usr/bin/foo" --bar`, or the quote is not closed on the same
+// line, as in `'s-Hertogenbosch`, the value is lexed as written instead.
+func lexQuotedValue(l *lexer) stateFunc {
+	q := l.next()
+	var val strings.Builder
+	for {
+		r := l.next()
+		switch {
+		case r == q:
+			end := l.pos
+			for r := l.peek(); r == space || r == tab; r = l.peek() {
+				l.next()
+			}
+			if r := l.peek(); r != eol && r != eof && !(r == '\r' && l.more(2) && l.input[l.pos+1] == eol) {
+				l.pos = l.start
+				return lexRawValue
+			}
+			l.pos = end
+			l.emit(TokenValue)
+			l.tok.val = val.String()
+			return lexLineStart
+		case r == eol || r == eof || r == '\r' && l.peek() == eol:
+			l.pos = l.start
+			return lexRawValue
+		case r == escape:
+			r = l.next()
+			if c, ok := escapes[r]; ok {
+				val.WriteRune(c)
+				continue
+			}
+			if r == 'u' && l.more(4) {
+				if n, err := strconv.ParseUint(l.input[l.pos:l.pos+4], 16, 16); err == nil && !utf16.IsSurrogate(rune(n)) {
+					l.pos += 4
+					val.WriteRune(rune(n))
+					continue
+				}
+			}
+			if line, _, _ := strings.Cut(l.input[l.pos:], "\n"); !strings.ContainsRune(line, q) {
+				l.pos = l.start
+				return lexRawValue
+			}
+			l.prev()
+			return l.error(InvalidEscape, l.current(), r, `escape sequences must be one of \n, \t, \r, \", \', \\ or \uXXXX`)
+		default:
+			val.WriteRune(r)
+		}
+	}
+}
@@ -1,7 +1,12 @@
 package ini
 
 import (
+	"bufio"
+	"strings"
 	"testing"
+	"testing/iotest"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestNext(t *testing.T) {
@@ -249,59 +254,59 @@ func TestLexerNextToken(t *testing.T) {
 			description: "simple case",
 			input:       "shell=/bin/bash",
 			want: []token{
-				{tokenPropKey, "shell"},
-				{tokenAssignment, "="},
-				{tokenPropValue, "/bin/bash"},
-				{tokenEOF, ""},
+				{typ: TokenKey, val: "shell"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "/bin/bash"},
+				{typ: TokenEOF, val: ""},
 			},
 		},
 		{
 			description: "section",
 			input:       "[user]",
 			want: []token{
-				{tokenSection, "user"},
-				{tokenEOF, ""},
+				{typ: TokenSection, val: "user"},
+				{typ: TokenEOF, val: ""},
 			},
 		},
 		{
 			description: "complete case",
 			input:       "; user\n[user]\nshell=/bin/bash\ngroup=wheel",
 			want: []token{
-				{tokenComment, `; user`},
-				{tokenSection, "user"},
-				{tokenPropKey, "shell"},
-				{tokenAssignment, "="},
-				{tokenPropValue, "/bin/bash"},
-				{tokenPropKey, "group"},
-				{tokenAssignment, "="},
-				{tokenPropValue, "wheel"},
-				{tokenEOF, ""},
+				{typ: TokenComment, val: `; user`},
+				{typ: TokenSection, val: "user"},
+				{typ: TokenKey, val: "shell"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "/bin/bash"},
+				{typ: TokenKey, val: "group"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "wheel"},
+				{typ: TokenEOF, val: ""},
 			},
 		},
 		{
 			description: "malformed section",
 			input:       "[user\nshell=/bin/bash",
 			want: []token{
-				{tokenError, `unexpected character: '\n', sections must be closed with a ']'`},
+				{typ: TokenError, val: `unexpected character: '\n', sections must be closed with a ']'`},
 			},
 		},
 		{
 			description: "empty value",
 			input:       "shell=",
 			want: []token{
-				{tokenPropKey, "shell"},
-				{tokenAssignment, "="},
-				{tokenError, `unexpected character: '\x00', an assignment must be followed by one or more alphanumeric characters`},
+				{typ: TokenKey, val: "shell"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenError, val: `unexpected character: '\x00', an assignment must be followed by one or more alphanumeric characters`},
 			},
 		},
 		{
 			description: "empty value accepted",
 			input:       "shell=",
 			want: []token{
-				{tokenPropKey, "shell"},
-				{tokenAssignment, "="},
-				{tokenPropValue, ""},
-				{tokenEOF, ""},
+				{typ: TokenKey, val: "shell"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: ""},
+				{typ: TokenEOF, val: ""},
 			},
 			opts: lexerOptions{allowEmptyValues: true},
 		},
@@ -309,20 +314,20 @@ func TestLexerNextToken(t *testing.T) {
 			description: "missing assignment",
 			input:       "shell",
 			want: []token{
-				{tokenError, `unexpected character: '\x00', a property key must be followed by the assignment character ('=')`},
+				{typ: TokenError, val: `unexpected character: '\x00', a property key must be followed by the assignment character ('=')`},
 			},
 		},
 		{
 			description: "whitespace multiline values",
 			input:       "shell=/bin/bash\n\n /bin/zsh\ngroup=wheel",
 			want: []token{
-				{tokenPropKey, "shell"},
-				{tokenAssignment, "="},
-				{tokenPropValue, "/bin/bash\n\n /bin/zsh"},
-				{tokenPropKey, "group"},
-				{tokenAssignment, "="},
-				{tokenPropValue, "wheel"},
-				{tokenEOF, ""},
+				{typ: TokenKey, val: "shell"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "/bin/bash\n\n /bin/zsh"},
+				{typ: TokenKey, val: "group"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "wheel"},
+				{typ: TokenEOF, val: ""},
 			},
 			opts: lexerOptions{allowMultilineWhitespacePrefix: true},
 		},
@@ -330,10 +335,10 @@ func TestLexerNextToken(t *testing.T) {
 			description: "escaped newline multiline values",
 			input:       "shell=/bin/bash\\\n/bin/zsh",
 			want: []token{
-				{tokenPropKey, "shell"},
-				{tokenAssignment, "="},
-				{tokenPropValue, "/bin/bash\\\n/bin/zsh"},
-				{tokenEOF, ""},
+				{typ: TokenKey, val: "shell"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "/bin/bash\\\n/bin/zsh"},
+				{typ: TokenEOF, val: ""},
 			},
 			opts: lexerOptions{allowMultilineEscapeNewline: true},
 		},
@@ -341,27 +346,27 @@ func TestLexerNextToken(t *testing.T) {
 			description: "map keys",
 			input:       "shell[win32]=PowerShell.exe\nshell[unix]=/bin/bash\nshell[]=sh",
 			want: []token{
-				{tokenPropKey, "shell"},
-				{tokenMapKey, "win32"},
-				{tokenAssignment, "="},
-				{tokenPropValue, "PowerShell.exe"},
-				{tokenPropKey, "shell"},
-				{tokenMapKey, "unix"},
-				{tokenAssignment, "="},
-				{tokenPropValue, "/bin/bash"},
-				{tokenPropKey, "shell"},
-				{tokenMapKey, ""},
-				{tokenAssignment, "="},
-				{tokenPropValue, "sh"},
-				{tokenEOF, ""},
+				{typ: TokenKey, val: "shell"},
+				{typ: TokenSubkey, val: "win32"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "PowerShell.exe"},
+				{typ: TokenKey, val: "shell"},
+				{typ: TokenSubkey, val: "unix"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "/bin/bash"},
+				{typ: TokenKey, val: "shell"},
+				{typ: TokenSubkey, val: ""},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "sh"},
+				{typ: TokenEOF, val: ""},
 			},
 		},
 		{
 			description: "number sign comments",
 			input:       "# this is a comment",
 			want: []token{
-				{tokenComment, "# this is a comment"},
-				{tokenEOF, ""},
+				{typ: TokenComment, val: "# this is a comment"},
+				{typ: TokenEOF, val: ""},
 			},
 			opts: lexerOptions{allowNumberSignComments: true},
 		},
@@ -369,28 +374,142 @@ func TestLexerNextToken(t *testing.T) {
 			description: "number sign comment causes error",
 			input:       "# this is a comment",
 			want: []token{
-				{tokenError, "unexpected character: '#', comments cannot begin with '#'; consider enabling Options.AllowNumberSignComments"},
+				{typ: TokenError, val: "unexpected character: '#', comments cannot begin with '#'; consider enabling Options.AllowNumberSignComments"},
 			},
 		},
 		{
 			description: "invalid line start",
 			input:       "% this is an invalid line",
 			want: []token{
-				{tokenError, "unexpected character: '%', lines can only begin with '[', ';', or alphanumeric characters"},
+				{typ: TokenError, val: "unexpected character: '%', lines can only begin with '[', ';', or alphanumeric characters"},
 			},
 		},
 		{
 			description: "unclosed map key",
 			input:       "shell[win32",
 			want: []token{
-				{tokenPropKey, "shell"},
-				{tokenError, "unexpected character: '\\x00', subkeys must be closed with a ']'"},
+				{typ: TokenKey, val: "shell"},
+				{typ: TokenError, val: "unexpected character: '\\x00', subkeys must be closed with a ']'"},
+			},
+		},
+		{
+			description: "whitespace after subkey",
+			input:       "shell[win32] = PowerShell.exe",
+			want: []token{
+				{typ: TokenKey, val: "shell"},
+				{typ: TokenSubkey, val: "win32"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "PowerShell.exe"},
+				{typ: TokenEOF, val: ""},
+			},
+		},
+		{
+			description: "whitespace around names and values",
+			input:       "[ user ]\nshell \t[ win32 ] = PowerShell.exe \t\nname =\troot ",
+			want: []token{
+				{typ: TokenSection, val: "user"},
+				{typ: TokenKey, val: "shell"},
+				{typ: TokenSubkey, val: "win32"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "PowerShell.exe"},
+				{typ: TokenKey, val: "name"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "root"},
+				{typ: TokenEOF, val: ""},
+			},
+		},
+		{
+			description: "missing assignment after subkey",
+			input:       "shell[win32]PowerShell.exe",
+			want: []token{
+				{typ: TokenKey, val: "shell"},
+				{typ: TokenSubkey, val: "win32"},
+				{typ: TokenError, val: "unexpected character: 'P', a property key must be followed by the assignment character ('=')"},
+			},
+		},
+		{
+			description: "text after quote",
+			input:       "a=\" padded \"\nExecStart=\"/usr/bin/foo\" --bar\ntitle=\"A\" and \"B\" \nb='it''s'",
+			want: []token{
+				{typ: TokenKey, val: "a"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: " padded "},
+				{typ: TokenKey, val: "ExecStart"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "\"/usr/bin/foo\" --bar"},
+				{typ: TokenKey, val: "title"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "\"A\" and \"B\""},
+				{typ: TokenKey, val: "b"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "'it''s'"},
+				{typ: TokenEOF, val: ""},
+			},
+		},
+		{
+			description: "escape sequences",
+			input:       "c = \"tab\\tquote\\\" \\u00e9\\\\\"  \r\nd=\"\"\ne='say \"hi\"\\n'",
+			want: []token{
+				{typ: TokenKey, val: "c"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "tab\tquote\" \u00e9\\"},
+				{typ: TokenKey, val: "d"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: ""},
+				{typ: TokenKey, val: "e"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "say \"hi\"\n"},
+				{typ: TokenEOF, val: ""},
+			},
+		},
+		{
+			description: "unclosed quote",
+			input:       "a=\"value\nb='s-Hertogenbosch\r\nc=\"C:\\dir",
+			want: []token{
+				{typ: TokenKey, val: "a"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "\"value"},
+				{typ: TokenKey, val: "b"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "'s-Hertogenbosch"},
+				{typ: TokenKey, val: "c"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "\"C:\\dir"},
+				{typ: TokenEOF, val: ""},
+			},
+		},
+		{
+			description: "invalid escape",
+			input:       "a=\"\\x41\"",
+			want: []token{
+				{typ: TokenKey, val: "a"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenError, val: `unexpected character: 'x', escape sequences must be one of \n, \t, \r, \", \', \\ or \uXXXX`},
+			},
+		},
+		{
+			description: "surrogate escape",
+			input:       "a=\"\\ud800\"",
+			want: []token{
+				{typ: TokenKey, val: "a"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenError, val: `unexpected character: 'u', escape sequences must be one of \n, \t, \r, \", \', \\ or \uXXXX`},
+			},
+		},
+		{
+			description: "quote inside value",
+			input:       "a=say \"hi\"",
+			want: []token{
+				{typ: TokenKey, val: "a"},
+				{typ: TokenAssignment, val: "="},
+				{typ: TokenValue, val: "say \"hi\""},
+				{typ: TokenEOF, val: ""},
 			},
 		},
 		{
 			description: "empty string",
 			input:       "",
-			want:        []token{{tokenEOF, ""}},
+			want:        []token{{typ: TokenEOF, val: ""}},
 		},
 	}
 
@@ -401,13 +520,186 @@ func TestLexerNextToken(t *testing.T) {
 			for i := 0; ; i++ {
 				got := l.nextToken()
 
-				if got != test.want[i] {
+				if got.typ != test.want[i].typ || got.val != test.want[i].val {
 					t.Fatalf("nextToken() = %v, want %v", got, test.want[i])
 				}
-				if got.typ == tokenEOF || got.typ == tokenError {
+				if got.typ == TokenEOF || got.typ == TokenError {
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestLexerLossless(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		opts        lexerOptions
+	}{
+		{
+			description: "complete case",
+			input:       "; user\n\n[user]\r\n  shell[unix]=/bin/bash\r\ngroup=wheel\n",
+		},
+		{
+			description: "multiline values",
+			input:       "shell=/bin/bash\\\r\n/bin/zsh\ngroup=wheel\n video\n",
+			opts:        lexerOptions{allowMultilineEscapeNewline: true, allowMultilineWhitespacePrefix: true},
+		},
+		{
+			description: "whitespace",
+			input:       "[ user ]\n\tshell [ unix ] =  /bin/bash \t\nname\t=\troot \n",
+		},
+		{
+			description: "keep whitespace",
+			input:       "[ user ]\n\tshell [ unix ] =  /bin/bash \t\nname\t=\troot \n",
+			opts:        lexerOptions{keepWhitespace: true},
+		},
+		{
+			description: "quoted values",
+			input:       "a = \" padded \"  \r\nb='\\u00e9\\n'\nc=\"/usr/bin/foo\" --bar \n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			l := lex(test.input)
+			l.opts = test.opts
+
+			var got string
+			for {
+				tok := l.nextToken()
+				if tok.typ == TokenError {
+					t.Fatal(tok.val)
+				}
+				got += tok.lead + tok.raw
+				if tok.typ == TokenEOF {
 					break
 				}
 			}
+			if got != test.input {
+				t.Errorf("%q != %q", got, test.input)
+			}
 		})
 	}
 }
+
+func TestLexerPosition(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		want        []Position
+	}{
+		{
+			description: "tokens",
+			input:       "; user\n[user]\r\n  shell[ü]=/bin/bash",
+			want: []Position{
+				{Offset: 0, Line: 1, Column: 1},
+				{Offset: 7, Line: 2, Column: 1},
+				{Offset: 17, Line: 3, Column: 3},
+				{Offset: 22, Line: 3, Column: 8},
+				{Offset: 26, Line: 3, Column: 11},
+				{Offset: 27, Line: 3, Column: 12},
+				{Offset: 36, Line: 3, Column: 21},
+			},
+		},
+		{
+			description: "unclosed section",
+			input:       "a=b\n[user\n",
+			want: []Position{
+				{Offset: 0, Line: 1, Column: 1},
+				{Offset: 1, Line: 1, Column: 2},
+				{Offset: 2, Line: 1, Column: 3},
+				{Offset: 9, Line: 2, Column: 6},
+			},
+		},
+		{
+			description: "invalid line start",
+			input:       "a=b\n  %",
+			want: []Position{
+				{Offset: 0, Line: 1, Column: 1},
+				{Offset: 1, Line: 1, Column: 2},
+				{Offset: 2, Line: 1, Column: 3},
+				{Offset: 6, Line: 2, Column: 3},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			l := lex(test.input)
+			for i := 0; ; i++ {
+				got := l.nextToken()
+
+				if got.pos != test.want[i] {
+					t.Fatalf("nextToken().pos = %v, want %v", got.pos, test.want[i])
+				}
+				if got.typ == TokenEOF || got.typ == TokenError {
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestLexReader(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		opts        lexerOptions
+	}{
+		{
+			description: "complete case",
+			input:       "; user\n\n[user]\r\n  shell[ü]=/bin/bash\r\ngroup=wheel\n",
+		},
+		{
+			description: "multiline values",
+			input:       "shell=/bin/bash\\\r\n/bin/zsh\ngroup=wheel\n video\n  audio\nuid=1000",
+			opts:        lexerOptions{allowMultilineEscapeNewline: true, allowMultilineWhitespacePrefix: true},
+		},
+		{
+			description: "errors",
+			input:       "a=b\n[user\nname\n  %\nc=d\n",
+			opts:        lexerOptions{continueOnError: true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			want := lex(test.input)
+			want.opts = test.opts
+			got := lexReader(bufio.NewReader(iotest.OneByteReader(strings.NewReader(test.input))))
+			got.opts = test.opts
+
+			for {
+				wantTok := want.nextToken()
+				gotTok := got.nextToken()
+				if !cmp.Equal(gotTok, wantTok, cmp.AllowUnexported(token{}, SyntaxError{})) {
+					t.Fatalf("nextToken() = %+v, want %+v", gotTok, wantTok)
+				}
+				if wantTok.typ == TokenEOF || (wantTok.typ == TokenError && !test.opts.continueOnError) {
+					break
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkLexer(b *testing.B) {
+	input := string(benchmarkInput(10000))
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l := lex(input)
+		for {
+			tok := l.nextToken()
+			if tok.typ == TokenEOF {
+				break
+			}
+			if tok.typ == TokenError {
+				b.Fatal(tok.err)
+			}
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package ini
 
-// The Options type is used to configure the behavior during unmarshalling.
+// The Options type is used to configure the behavior during marshalling and
+// unmarshalling. The zero value of Options configures the default behavior.
 type Options struct {
 	// AllowMultilineValues enables a property value to contain multiple lines.
 	// Currently supported methods:
@@ -15,4 +16,106 @@ type Options struct {
 
 	// AllowEmptyValues permits a key to have an empty assignment.
 	AllowEmptyValues bool
+
+	// KeepWhitespace retains the spaces and tabs surrounding section names,
+	// property keys, subkeys and values, so that "key = value" assigns the
+	// value " value" to the key "key ". By default, such whitespace is
+	// trimmed.
+	KeepWhitespace bool
+
+	// ContinueOnError resumes parsing at the next line after a syntax error,
+	// so that every syntax error in the input is reported at once. The errors
+	// are returned joined, as by errors.Join. Parse returns the partially
+	// parsed File along with the errors; lines containing an error are
+	// retained as source text but otherwise ignored, as are the lines
+	// following a malformed section header, up to the next section header.
+	ContinueOnError bool
+
+	// DisallowUnknownKeys causes decoding into a struct to return an error
+	// for each property key that no struct field of its section matches,
+	// rather than ignoring the key.
+	DisallowUnknownKeys bool
+
+	// DisallowUnknownSections causes decoding into a struct to return an
+	// error for each section that no struct field matches, rather than
+	// ignoring the section.
+	DisallowUnknownSections bool
+
+	// CaseInsensitive matches section names and property keys to struct
+	// fields without regard to case when decoding, as Windows and Python's
+	// configparser do, so that the key "Name" matches the field tag "name".
+	CaseInsensitive bool
+
+	// FieldNaming, if set, derives the section name or property key of each
+	// struct field without a name in its field tag from the field's name, both
+	// when encoding and decoding. SnakeCase, KebabCase and strings.ToLower may
+	// be used, or any other function. If nil, the field's name is used as is.
+	FieldNaming func(name string) string
+
+	// SpaceAroundAssignment writes a space on each side of the assignment
+	// character when encoding, as in "key = value".
+	SpaceAroundAssignment bool
+
+	// BlankLines controls the blank lines written between sections when
+	// encoding.
+	BlankLines BlankLinePolicy
+
+	// CRLF writes "\r\n" line endings when encoding, rather than "\n".
+	CRLF bool
+
+	// CommentChar is the character that begins comments written by the
+	// Encoder. If zero, a semicolon (;) is used. Comments beginning with the
+	// number sign (#) can only be decoded with AllowNumberSignComments.
+	CommentChar rune
+
+	// WriteEmptyValues encodes values that are empty, such as an empty string,
+	// as a property assigned an empty quoted string ("") rather than omitting
+	// the property.
+	WriteEmptyValues bool
+
+	// OmitDefaults omits a struct field from the encoding if its value equals
+	// the default given by the "default=" option of its field tag, so that only
+	// values that differ from their defaults are written.
+	OmitDefaults bool
+}
+
+// A BlankLinePolicy controls where an Encoder writes blank lines.
+type BlankLinePolicy int
+
+const (
+	// BlankLineBetweenSections writes a blank line before each section header
+	// that follows another line.
+	BlankLineBetweenSections BlankLinePolicy = iota
+
+	// NoBlankLines writes no blank lines.
+	NoBlankLines
+)
+
+// lexerOptions returns the lexer configuration corresponding to o.
+func (o Options) lexerOptions() lexerOptions {
+	return lexerOptions{
+		allowMultilineEscapeNewline:    o.AllowMultilineValues,
+		allowMultilineWhitespacePrefix: o.AllowMultilineValues,
+		allowNumberSignComments:        o.AllowNumberSignComments,
+		allowEmptyValues:               o.AllowEmptyValues,
+		continueOnError:                o.ContinueOnError,
+		keepWhitespace:                 o.KeepWhitespace,
+	}
+}
+
+// newline returns the line ending written when encoding with o.
+func (o Options) newline() string {
+	if o.CRLF {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// commentChar returns the character that begins comments written when
+// encoding with o.
+func (o Options) commentChar() rune {
+	if o.CommentChar == 0 {
+		return comment
+	}
+	return o.CommentChar
 }
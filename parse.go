@@ -1,22 +1,128 @@
 package ini
 
-// unexpectedTokenErr describes a token that was not expected by the parser in
-// the lexer's current state.
-type unexpectedTokenErr struct {
-	got token
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// An ErrorKind classifies the syntax error described by a SyntaxError.
+type ErrorKind int
+
+const (
+	// UnexpectedToken indicates a token that is not valid at its position.
+	UnexpectedToken ErrorKind = iota
+	// IllegalCharacter indicates a line that begins with a character that
+	// cannot begin a line.
+	IllegalCharacter
+	// UnclosedSection indicates a section header missing its closing ']'.
+	UnclosedSection
+	// UnclosedSubkey indicates a subkey missing its closing ']'.
+	UnclosedSubkey
+	// MissingAssignment indicates a property key that is not followed by the
+	// assignment character ('=').
+	MissingAssignment
+	// EmptyValue indicates an assignment with no value, when empty values are
+	// not permitted.
+	EmptyValue
+	// InvalidEscape indicates an unknown escape sequence in a quoted value.
+	InvalidEscape
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case UnexpectedToken:
+		return "unexpected token"
+	case IllegalCharacter:
+		return "illegal character"
+	case UnclosedSection:
+		return "unclosed section"
+	case UnclosedSubkey:
+		return "unclosed subkey"
+	case MissingAssignment:
+		return "missing assignment"
+	case EmptyValue:
+		return "empty value"
+	case InvalidEscape:
+		return "invalid escape"
+	}
+	return "ErrorKind(" + strconv.Itoa(int(k)) + ")"
 }
 
-func (e unexpectedTokenErr) Error() string {
-	return "unexpected token: " + e.got.val
+// A SyntaxError describes malformed INI source text.
+type SyntaxError struct {
+	Kind ErrorKind // the kind of error
+	Pos  Position  // the position at which the error was detected
+	Text string    // the offending source text
+	Msg  string    // a description of the error
+	line string    // the line of source text containing the error
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+// Excerpt returns the line of source text containing the error, followed by a
+// line with a caret marking the column at which the error was detected:
+//
+//	3 | [group
+//	  |       ^
+func (e *SyntaxError) Excerpt() string {
+	num := strconv.Itoa(e.Pos.Line)
+	gutter := strings.Repeat(" ", len(num))
+
+	var caret strings.Builder
+	for i, r := range []rune(e.line) {
+		if i >= e.Pos.Column-1 {
+			break
+		}
+		if r == tab {
+			caret.WriteRune(tab)
+		} else {
+			caret.WriteRune(space)
+		}
+	}
+	for i := utf8.RuneCountInString(e.line); i < e.Pos.Column-1; i++ {
+		caret.WriteRune(space)
+	}
+	caret.WriteRune('^')
+
+	return num + " | " + e.line + "\n" + gutter + " | " + caret.String() + "\n"
+}
+
+// unexpected returns a SyntaxError describing the current token as an
+// unexpected token, or the error carried by the token if it is a TokenError.
+func (p *parser) unexpected() *SyntaxError {
+	if p.tok.err != nil {
+		return p.tok.err
+	}
+	return &SyntaxError{
+		Kind: UnexpectedToken,
+		Pos:  p.tok.pos,
+		Text: p.tok.raw,
+		Msg:  "unexpected token: " + p.tok.val,
+		line: p.l.lineAt(p.tok.pos.Offset - p.l.base),
+	}
 }
 
 type parser struct {
-	tree parseTree
-	l    *lexer
-	tok  token
-	prev *token
+	tree   parseTree
+	l      *lexer
+	tok    token
+	prev   *token
+	trivia string     // comments and blank lines not yet attached to the tree
+	props  []property // scratch space for the properties of a section
+	errs   []error    // errors recovered from when continuing on error
 }
 
+// newParser returns a parser that parses data. The data is copied once into a
+// string, from which tokens are then sliced without further allocation. The
+// copy is required: the parse tree, and so a File, retains slices of the input
+// as its source text, and decoded string values are slices of it too, none of
+// which may change if the caller later modifies data.
 func newParser(data []byte) *parser {
 	p := parser{
 		tree: newParseTree(),
@@ -25,6 +131,15 @@ func newParser(data []byte) *parser {
 	return &p
 }
 
+// newReaderParser returns a parser that reads its input from r as it parses.
+func newReaderParser(r *bufio.Reader) *parser {
+	p := parser{
+		tree: newParseTree(),
+		l:    lexReader(r),
+	}
+	return &p
+}
+
 func (p *parser) nextToken() {
 	if p.prev != nil {
 		p.tok = *p.prev
@@ -38,39 +153,123 @@ func (p *parser) backup() {
 	p.prev = &p.tok
 }
 
+// join returns the concatenation of s and t, which are adjacent in the source
+// text and end at the byte offset end. Where possible, the result is sliced
+// from the input rather than allocated.
+func (p *parser) join(s, t string, end int) string {
+	if s == "" {
+		return t
+	}
+	if t == "" {
+		return s
+	}
+	if src, ok := p.l.source(end-len(s)-len(t), end); ok {
+		return src
+	}
+	return s + t
+}
+
+// source returns the source text of the current token, including its lead.
+func (p *parser) source() string {
+	return p.join(p.tok.lead, p.tok.raw, p.tok.pos.Offset+len(p.tok.raw))
+}
+
+// leading returns the source text that precedes the current token, including
+// any comments and blank lines skipped since the last element of the tree.
+func (p *parser) leading() string {
+	leading := p.join(p.trivia, p.tok.lead, p.tok.pos.Offset)
+	p.trivia = ""
+	return leading
+}
+
+// skip adds the source text of the current token to the trivia.
+func (p *parser) skip() {
+	p.trivia = p.join(p.trivia, p.source(), p.tok.pos.Offset+len(p.tok.raw))
+}
+
+// trailing peeks at the next token and returns the source text that remains
+// on the current line, up to and including the line ending.
+func (p *parser) trailing() string {
+	p.nextToken()
+	i := strings.IndexByte(p.tok.lead, eol) + 1
+	trailing := p.tok.lead[:i]
+	p.tok.lead = p.tok.lead[i:]
+	p.backup()
+	return trailing
+}
+
+// recover records err and returns nil if the parser is configured to continue
+// on error, retaining src, the source text consumed by the element that failed
+// to parse, and the current token as trivia. Otherwise it returns err.
+func (p *parser) recover(err error, src string) error {
+	if !p.l.opts.continueOnError {
+		return err
+	}
+	p.errs = append(p.errs, err)
+	p.trivia += src
+	p.skip()
+	return nil
+}
+
 // parse advances the token scanner repeatedly, constructing a parseTree on
 // each step through the token stream until an EOF token is encountered.
 func (p *parser) parse() error {
 	for {
-		if p.tok.typ == tokenEOF {
-			return nil
-		}
-
 		p.nextToken()
 		switch p.tok.typ {
-		case tokenEOF:
-			return nil
-		case tokenError:
-			return &unexpectedTokenErr{p.tok}
-		case tokenSection:
-			sec := newSection(p.tok.val)
-			if err := p.parseSection(&sec); err != nil {
+		case TokenEOF:
+			p.tree.trailing = p.leading()
+			return errors.Join(p.errs...)
+		case TokenSection:
+			sec := newSection("")
+			if err := p.parseSection(sec); err != nil {
 				return err
 			}
 			p.tree.add(sec)
-		case tokenPropKey:
-			prop, err := p.tree.global.get(p.tok.val)
-			if err != nil {
+		case TokenKey:
+			var prop property
+			if err := p.parseProperty(&prop); err != nil {
+				var buf bytes.Buffer
+				prop.write(&buf)
+				if err := p.recover(err, buf.String()); err != nil {
+					return err
+				}
+				continue
+			}
+			p.tree.global.add(prop)
+		case TokenComment:
+			p.skip()
+		default:
+			unclosed := p.tok.err != nil && p.tok.err.Kind == UnclosedSection
+			if err := p.recover(p.unexpected(), ""); err != nil {
 				return err
 			}
-			if err := p.parseProperty(prop); err != nil {
+			if unclosed {
+				if err := p.skipSection(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// skipSection adds the source text of every token up to the next section
+// header to the trivia, so that the properties following a section header
+// that failed to parse are not attributed to the section preceding it. Errors
+// among the skipped tokens are still recorded.
+func (p *parser) skipSection() error {
+	for {
+		p.nextToken()
+		switch p.tok.typ {
+		case TokenSection, TokenEOF:
+			p.backup()
+			return nil
+		case TokenError:
+			if err := p.recover(p.unexpected(), ""); err != nil {
 				return err
 			}
-			p.tree.global.add(*prop)
-		case tokenComment:
-			continue
 		default:
-			return &unexpectedTokenErr{got: p.tok}
+			p.skip()
 		}
 	}
 }
@@ -78,57 +277,79 @@ func (p *parser) parse() error {
 // parseSection repeatedly advances the token scanner, constructing a section
 // parseTree element from the scanned values.
 func (p *parser) parseSection(out *section) error {
-	name := p.tok.val
-	out.name = name
+	out.name = p.tok.val
+	out.pos = p.tok.pos
+	out.raw.leading = p.leading()
+	out.raw.header = p.tok.raw
+	out.raw.trailing = p.trailing()
+
+	// Properties are collected in scratch space shared between sections, so
+	// that out only needs to be allocated once.
+	props := p.props[:0]
+	defer func() {
+		out.props = append(out.props, props...)
+		p.props = props
+	}()
 
 	for {
 		p.nextToken()
 		switch p.tok.typ {
-		case tokenError:
-			return &unexpectedTokenErr{got: p.tok}
-		case tokenPropKey:
-			prop, err := out.get(p.tok.val)
-			if err != nil {
-				return err
+		case TokenError:
+			if p.tok.err != nil && p.tok.err.Kind == UnclosedSection {
+				// The broken header ends this section; parse skips what follows.
+				p.backup()
+				return nil
 			}
-			if err := p.parseProperty(prop); err != nil {
+			if err := p.recover(p.unexpected(), ""); err != nil {
 				return err
 			}
-			out.add(*prop)
-		case tokenSection:
+		case TokenKey:
+			var prop property
+			if err := p.parseProperty(&prop); err != nil {
+				var buf bytes.Buffer
+				prop.write(&buf)
+				if err := p.recover(err, buf.String()); err != nil {
+					return err
+				}
+				continue
+			}
+			props = append(props, prop)
+		case TokenComment:
+			p.skip()
+		default:
 			// we've parsed too far; backup so we can parse the next section
 			p.backup()
 			return nil
-		case tokenComment:
-			continue
-		default:
-			return nil
 		}
 	}
 }
 
-// parseProperty repeatedly advances the token scanner, constructing a property
-// parseTree element from the scanned values.
+// parseProperty advances the token scanner over a single assignment,
+// constructing a property parseTree element from the scanned values.
 func (p *parser) parseProperty(out *property) error {
-	key := p.tok.val
-	subkey := ""
+	out.key = p.tok.val
+	out.pos = p.tok.pos
+	out.raw.leading = p.leading()
 
 	p.nextToken()
-	if p.tok.typ == tokenMapKey {
-		subkey = p.tok.val
+	if p.tok.typ == TokenSubkey {
+		out.subkey = p.tok.val
+		out.raw.subkey = p.source()
 		p.nextToken()
 	}
+	if p.tok.typ != TokenAssignment {
+		return p.unexpected()
+	}
+	out.raw.sep = p.source()
 
 	p.nextToken()
-	if p.tok.typ != tokenPropValue {
-		return &unexpectedTokenErr{
-			got: p.tok,
-		}
+	if p.tok.typ != TokenValue {
+		return p.unexpected()
 	}
-	val := p.tok.val
-
-	out.key = key
-	out.add(subkey, val)
+	out.val = p.tok.val
+	out.raw.sep = p.join(out.raw.sep, p.tok.lead, p.tok.pos.Offset)
+	out.raw.val = p.tok.raw
+	out.raw.trailing = p.trailing()
 
 	return nil
 }
@@ -1,6 +1,8 @@
 package ini
 
 import (
+	"bytes"
+	"errors"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -19,18 +21,11 @@ func TestParse(t *testing.T) {
 			input: "[user]\nshell=/bin/bash",
 			want: parseTree{
 				global: newSection(""),
-				sections: map[string][]section{
-					"user": {
-						{
-							name: "user",
-							props: map[string]property{
-								"shell": {
-									key: "shell",
-									vals: map[string][]string{
-										"": {"/bin/bash"},
-									},
-								},
-							},
+				sections: []*section{
+					{
+						name: "user",
+						props: []property{
+							{key: "shell", val: "/bin/bash"},
 						},
 					},
 				},
@@ -39,72 +34,40 @@ func TestParse(t *testing.T) {
 		{
 			input: "Greeting[en]=Hello\nGreeting[fr]=Bonjour",
 			want: parseTree{
-				global: section{
+				global: &section{
 					name: "",
-					props: map[string]property{
-						"Greeting": {
-							key: "Greeting",
-							vals: map[string][]string{
-								"en": {"Hello"},
-								"fr": {"Bonjour"},
-							},
-						},
+					props: []property{
+						{key: "Greeting", subkey: "en", val: "Hello"},
+						{key: "Greeting", subkey: "fr", val: "Bonjour"},
 					},
 				},
-				sections: map[string][]section{},
+				sections: []*section{},
 			},
 		},
 		{
 			input: "source=passwd\n[user]\nname=root\nshell[unix]=/bin/bash\nshell[win32]=PowerShell.exe\n[user]\nname=admin\nshell[unix]=/bin/bash\nshell[win32]=PowerShell.exe",
 			want: parseTree{
-				global: section{
+				global: &section{
 					name: "",
-					props: map[string]property{
-						"source": {
-							key: "source",
-							vals: map[string][]string{
-								"": {"passwd"},
-							},
-						},
+					props: []property{
+						{key: "source", val: "passwd"},
 					},
 				},
-				sections: map[string][]section{
-					"user": {
-						{
-							name: "user",
-							props: map[string]property{
-								"name": {
-									key: "name",
-									vals: map[string][]string{
-										"": {"root"},
-									},
-								},
-								"shell": {
-									key: "shell",
-									vals: map[string][]string{
-										"unix":  {"/bin/bash"},
-										"win32": {"PowerShell.exe"},
-									},
-								},
-							},
+				sections: []*section{
+					{
+						name: "user",
+						props: []property{
+							{key: "name", val: "root"},
+							{key: "shell", subkey: "unix", val: "/bin/bash"},
+							{key: "shell", subkey: "win32", val: "PowerShell.exe"},
 						},
-						{
-							name: "user",
-							props: map[string]property{
-								"name": {
-									key: "name",
-									vals: map[string][]string{
-										"": {"admin"},
-									},
-								},
-								"shell": {
-									key: "shell",
-									vals: map[string][]string{
-										"unix":  {"/bin/bash"},
-										"win32": {"PowerShell.exe"},
-									},
-								},
-							},
+					},
+					{
+						name: "user",
+						props: []property{
+							{key: "name", val: "admin"},
+							{key: "shell", subkey: "unix", val: "/bin/bash"},
+							{key: "shell", subkey: "win32", val: "PowerShell.exe"},
 						},
 					},
 				},
@@ -122,7 +85,7 @@ func TestParse(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		if !cmp.Equal(p.tree, test.want, cmp.Options{cmp.AllowUnexported(section{}, property{}, parseTree{})}) {
+		if !cmp.Equal(p.tree, test.want, cmp.Options{cmp.AllowUnexported(section{}, property{}, parseTree{}), ignoreSource}) {
 			t.Fatalf("%+v != %+v", p.tree, test.want)
 		}
 	}
@@ -132,37 +95,43 @@ func TestParseProp(t *testing.T) {
 	tests := []struct {
 		description string
 		input       string
-		want        property
+		want        []property
 		shouldError bool
 		wantError   error
 	}{
 		{
 			description: "valid property",
 			input:       "Greeting[en]=Hello\nGreeting[fr]=Bonjour",
-			want: property{
-				key: "Greeting",
-				vals: map[string][]string{
-					"en": {"Hello"},
-					"fr": {"Bonjour"},
-				},
+			want: []property{
+				{key: "Greeting", subkey: "en", val: "Hello"},
+				{key: "Greeting", subkey: "fr", val: "Bonjour"},
 			},
 		},
 		{
 			description: "unexpected token, missing property value",
 			input:       "Greeting=",
-			want:        property{"", map[string][]string{"": {}}},
+			want:        []property{},
 			shouldError: true,
-			wantError:   &unexpectedTokenErr{token{tokenError, `unexpected character: '\x00', an assignment must be followed by one or more alphanumeric characters`}},
+			wantError: &SyntaxError{
+				Kind: EmptyValue,
+				Pos:  Position{Offset: 9, Line: 1, Column: 10},
+				Text: "",
+				Msg:  `unexpected character: '\x00', an assignment must be followed by one or more alphanumeric characters`,
+				line: "Greeting=",
+			},
 		},
 		{
 			description: "empty string",
 			input:       "",
-			want: property{
-				key:  "",
-				vals: map[string][]string{},
-			},
+			want:        []property{},
 			shouldError: true,
-			wantError:   &unexpectedTokenErr{token{tokenEOF, ""}},
+			wantError: &SyntaxError{
+				Kind: UnexpectedToken,
+				Pos:  Position{Offset: 0, Line: 1, Column: 1},
+				Text: "",
+				Msg:  "unexpected token: ",
+				line: "",
+			},
 		},
 	}
 
@@ -172,27 +141,29 @@ func TestParseProp(t *testing.T) {
 			p := newParser([]byte(test.input))
 			p.nextToken()
 
-			got := newProperty(p.tok.val)
+			got := make([]property, 0)
 			for {
-				err = p.parseProperty(&got)
+				var prop property
+				err = p.parseProperty(&prop)
 				if err != nil {
 					break
 				}
+				got = append(got, prop)
 				p.nextToken()
-				if p.tok.typ == tokenEOF {
+				if p.tok.typ == TokenEOF {
 					break
 				}
 			}
 			if test.shouldError {
-				if !cmp.Equal(err, test.wantError, cmp.AllowUnexported(unexpectedTokenErr{}, token{})) {
+				if !cmp.Equal(err, test.wantError, cmp.AllowUnexported(SyntaxError{})) {
 					t.Fatalf("parseProperty(%v) returned %v, want %v", test.input, err, test.wantError)
 				}
 			} else {
 				if err != nil {
 					t.Fatalf("parseProperty(%v) returned %v, want %v", test.input, err, test.wantError)
 				}
-				if !cmp.Equal(got, test.want, cmp.Options{cmp.AllowUnexported(property{})}) {
-					t.Errorf("parseProperty(%v) = %v, want %v\ndiff -want +got\n%v", test.input, got, test.want, cmp.Diff(test.want, got))
+				if !cmp.Equal(got, test.want, cmp.Options{cmp.AllowUnexported(property{}), ignoreSource}) {
+					t.Errorf("parseProperty(%v) = %v, want %v\ndiff -want +got\n%v", test.input, got, test.want, cmp.Diff(test.want, got, cmp.AllowUnexported(property{}), ignoreSource))
 				}
 			}
 		})
@@ -203,18 +174,18 @@ func TestParseSection(t *testing.T) {
 	tests := []struct {
 		description string
 		input       string
-		want        section
+		want        *section
 		shouldError bool
 		wantError   error
 	}{
 		{
 			description: "valid",
 			input:       "[user]\n; UNIX user name\nname=root\n; Default shell\nshell=/bin/bash",
-			want: section{
+			want: &section{
 				name: "user",
-				props: map[string]property{
-					"name":  {"name", map[string][]string{"": {"root"}}},
-					"shell": {"shell", map[string][]string{"": {"/bin/bash"}}},
+				props: []property{
+					{key: "name", val: "root"},
+					{key: "shell", val: "/bin/bash"},
 				},
 			},
 		},
@@ -225,7 +196,7 @@ func TestParseSection(t *testing.T) {
 			p := newParser([]byte(test.input))
 			p.nextToken()
 			got := newSection(p.tok.val)
-			err := p.parseSection(&got)
+			err := p.parseSection(got)
 
 			if test.shouldError {
 				if !cmp.Equal(err, test.wantError) {
@@ -235,10 +206,207 @@ func TestParseSection(t *testing.T) {
 				if err != nil {
 					t.Fatalf("parseSection(%v) returned %v, want %v", test.input, err, test.wantError)
 				}
-				if !cmp.Equal(got, test.want, cmp.Options{cmp.AllowUnexported(property{}, section{})}) {
-					t.Errorf("parseSection(%v) = %v, want %v\ndiff -want +got\n%v", test.input, got, test.want, cmp.Diff(test.want, got))
+				if !cmp.Equal(got, test.want, cmp.Options{cmp.AllowUnexported(property{}, section{}), ignoreSource}) {
+					t.Errorf("parseSection(%v) = %v, want %v\ndiff -want +got\n%v", test.input, got, test.want, cmp.Diff(test.want, got, cmp.AllowUnexported(property{}, section{}), ignoreSource))
 				}
 			}
 		})
 	}
 }
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		want        string
+	}{
+		{
+			description: "unclosed section",
+			input:       "[user]\nname=root\n[group\n",
+			want:        `3:7: unexpected character: '\n', sections must be closed with a ']'`,
+		},
+		{
+			description: "missing assignment",
+			input:       "[user]\n\nname\n",
+			want:        `3:5: unexpected character: '\n', a property key must be followed by the assignment character ('=')`,
+		},
+		{
+			description: "invalid line start",
+			input:       "[user]\n  -name=root\n",
+			want:        `2:3: unexpected character: '-', lines can only begin with '[', ';', or alphanumeric characters`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			p := newParser([]byte(test.input))
+			err := p.parse()
+			if err == nil {
+				t.Fatalf("parse(%q) returned nil, want %v", test.input, test.want)
+			}
+			if got := err.Error(); got != test.want {
+				t.Errorf("parse(%q) returned %v, want %v", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		want        *SyntaxError
+		wantExcerpt string
+	}{
+		{
+			description: "unclosed section",
+			input:       "[user]\nname=root\n[group\n",
+			want: &SyntaxError{
+				Kind: UnclosedSection,
+				Pos:  Position{Offset: 23, Line: 3, Column: 7},
+				Text: "[group",
+				Msg:  `unexpected character: '\n', sections must be closed with a ']'`,
+				line: "[group",
+			},
+			wantExcerpt: "3 | [group\n  |       ^\n",
+		},
+		{
+			description: "unclosed subkey",
+			input:       "\tshell[unix=/bin/bash",
+			want: &SyntaxError{
+				Kind: UnclosedSubkey,
+				Pos:  Position{Offset: 21, Line: 1, Column: 22},
+				Text: "[unix=/bin/bash",
+				Msg:  `unexpected character: '\x00', subkeys must be closed with a ']'`,
+				line: "\tshell[unix=/bin/bash",
+			},
+			wantExcerpt: "1 | \tshell[unix=/bin/bash\n  | \t                    ^\n",
+		},
+		{
+			description: "missing assignment",
+			input:       "a=1\nb=2\nc=3\nd=4\ne=5\nf=6\ng=7\nh=8\ni=9\nname\r\n",
+			want: &SyntaxError{
+				Kind: MissingAssignment,
+				Pos:  Position{Offset: 40, Line: 10, Column: 5},
+				Text: "name",
+				Msg:  `unexpected character: '\r', a property key must be followed by the assignment character ('=')`,
+				line: "name",
+			},
+			wantExcerpt: "10 | name\n   |     ^\n",
+		},
+		{
+			description: "illegal character",
+			input:       "[user]\n# name\n",
+			want: &SyntaxError{
+				Kind: IllegalCharacter,
+				Pos:  Position{Offset: 7, Line: 2, Column: 1},
+				Text: "#",
+				Msg:  `unexpected character: '#', comments cannot begin with '#'; consider enabling Options.AllowNumberSignComments`,
+				line: "# name",
+			},
+			wantExcerpt: "2 | # name\n  | ^\n",
+		},
+		{
+			description: "invalid escape",
+			input:       "name='\\u00zz'\n",
+			want: &SyntaxError{
+				Kind: InvalidEscape,
+				Pos:  Position{Offset: 7, Line: 1, Column: 8},
+				Text: "'\\",
+				Msg:  `unexpected character: 'u', escape sequences must be one of \n, \t, \r, \", \', \\ or \uXXXX`,
+				line: "name='\\u00zz'",
+			},
+			wantExcerpt: "1 | name='\\u00zz'\n  |        ^\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			_, err := Parse([]byte(test.input))
+
+			var got *SyntaxError
+			if !errors.As(err, &got) {
+				t.Fatalf("Parse(%q) returned %v, want *SyntaxError", test.input, err)
+			}
+			if !cmp.Equal(got, test.want, cmp.AllowUnexported(SyntaxError{})) {
+				t.Errorf("Parse(%q) returned %v, want %v\ndiff -want +got\n%v", test.input, got, test.want, cmp.Diff(test.want, got, cmp.AllowUnexported(SyntaxError{})))
+			}
+			if excerpt := got.Excerpt(); excerpt != test.wantExcerpt {
+				t.Errorf("Excerpt() = %q, want %q", excerpt, test.wantExcerpt)
+			}
+		})
+	}
+}
+
+func TestParserContinueOnError(t *testing.T) {
+	input := "version\n[user]\nname=root\n% comment\nshell[unix=/bin/bash\ngroup=\n[group\nuid=1000\n"
+
+	p := newParser([]byte(input))
+	p.l.opts.continueOnError = true
+	err := p.parse()
+
+	want := []string{
+		`1:8: unexpected character: '\n', a property key must be followed by the assignment character ('=')`,
+		`4:1: unexpected character: '%', lines can only begin with '[', ';', or alphanumeric characters`,
+		`5:21: unexpected character: '\n', subkeys must be closed with a ']'`,
+		`6:7: unexpected character: '\n', an assignment must be followed by one or more alphanumeric characters`,
+		`7:7: unexpected character: '\n', sections must be closed with a ']'`,
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("parse() returned %v, want joined errors", err)
+	}
+	got := make([]string, 0)
+	for _, err := range joined.Unwrap() {
+		got = append(got, err.Error())
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("parse() returned %v, want %v\ndiff -want +got\n%v", got, want, cmp.Diff(want, got))
+	}
+
+	wantTree := parseTree{
+		global: newSection(""),
+		sections: []*section{
+			{
+				name: "user",
+				props: []property{
+					{key: "name", val: "root"},
+				},
+			},
+		},
+	}
+	if !cmp.Equal(p.tree, wantTree, cmp.Options{cmp.AllowUnexported(section{}, property{}, parseTree{}), ignoreSource}) {
+		t.Errorf("%+v != %+v", p.tree, wantTree)
+	}
+
+	var buf bytes.Buffer
+	p.tree.write(&buf)
+	if buf.String() != input {
+		t.Errorf("write() = %q, want %q", buf.String(), input)
+	}
+}
+
+// BenchmarkParse reports allocations that include the copy of the input made
+// by newParser, which BenchmarkNewParser measures on its own.
+func BenchmarkParse(b *testing.B) {
+	data := benchmarkInput(10000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p := newParser(data)
+		if err := p.parse(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNewParser(b *testing.B) {
+	data := benchmarkInput(10000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		newParser(data)
+	}
+}
@@ -1,5 +1,11 @@
 package ini
 
+import (
+	"bytes"
+	"slices"
+	"strings"
+)
+
 type invalidKeyErr struct {
 	err string
 }
@@ -8,99 +14,486 @@ func (e *invalidKeyErr) Error() string {
 	return "invalid key: " + e.err
 }
 
+// A parseTree holds the sections of a parsed INI document in the order they
+// appear in the source.
 type parseTree struct {
-	global   section
-	sections map[string][]section
+	global   *section
+	sections []*section
+	trailing string // source text following the last section or property
+	fold     bool   // section names are compared case-insensitively
 }
 
 func newParseTree() parseTree {
 	return parseTree{
 		global:   newSection(""),
-		sections: make(map[string][]section),
+		sections: make([]*section, 0),
 	}
 }
 
-func (p *parseTree) add(s section) {
-	sections, ok := p.sections[s.name]
-	if !ok {
-		sections = make([]section, 0)
+func (p *parseTree) add(s *section) {
+	p.sections = append(p.sections, s)
+}
+
+// err returns the first error recorded by a section of p, if any.
+func (p *parseTree) err() error {
+	if p.global.err != nil {
+		return p.global.err
 	}
-	sections = append(sections, s)
-	p.sections[s.name] = sections
+	for _, s := range p.sections {
+		if s.err != nil {
+			return s.err
+		}
+	}
+	return nil
 }
 
-func (p *parseTree) get(name string) ([]section, error) {
+// foldCase causes the section names of p, and the property keys of each of its
+// sections, to be compared case-insensitively.
+func (p *parseTree) foldCase() {
+	p.fold = true
+	p.global.fold = true
+	for _, s := range p.sections {
+		s.fold = true
+	}
+}
+
+// get returns every section named name, in source order. Names are compared
+// by their section path, so that "server.tls" names both the [server.tls] and
+// [server "tls"] sections. If name contains an asterisk, it is a pattern that
+// every matching section is returned for, as described by matchSection; the
+// name "*" returns all sections.
+func (p *parseTree) get(name string) ([]*section, error) {
 	if name == "" {
 		return nil, &invalidKeyErr{"section name cannot be empty"}
 	}
 	if name == "*" {
-		sections := make([]section, 0)
-		for _, v := range p.sections {
-			sections = append(sections, v...)
+		sections := make([]*section, 0, len(p.sections))
+		return append(sections, p.sections...), nil
+	}
+	name = sectionPath(name)
+	sections := make([]*section, 0)
+	for _, s := range p.sections {
+		if _, ok := matchSection(name, sectionPath(s.name), p.fold); ok {
+			sections = append(sections, s)
 		}
-		return sections, nil
 	}
-	sections, ok := p.sections[name]
-	if !ok {
+	if len(sections) == 0 {
 		return nil, &invalidKeyErr{"section '" + name + "' does not exist"}
 	}
 	return sections, nil
 }
 
+// hasSubsections reports whether p has a section nested below the section
+// path name, such as [server.tls] below "server".
+func (p *parseTree) hasSubsections(name string) bool {
+	prefix := sectionPath(name) + "."
+	for _, s := range p.sections {
+		if hasPrefix(sectionPath(s.name), prefix, p.fold) {
+			return true
+		}
+	}
+	return false
+}
+
+// sectionPath returns the dotted path of the section named name. A name with a
+// quoted subsection, such as `server "tls"`, has the same path as the
+// equivalent dotted name, "server.tls", as does a section nested below a
+// quoted subsection, such as `remote "origin".tls`.
+func sectionPath(name string) string {
+	base, sub, rest, ok := subsection(name)
+	if !ok {
+		return name
+	}
+	return base + "." + sub + rest
+}
+
+// subsection splits the section named name into its base name, its quoted
+// subsection name and the rest of the name following the closing quote, such
+// as "remote", "origin" and ".tls" for `remote "origin".tls`. The rest is
+// either empty or begins with a dot. It reports whether name has a quoted
+// subsection.
+func subsection(name string) (base, sub, rest string, ok bool) {
+	base, after, ok := strings.Cut(name, `"`)
+	base = strings.TrimSpace(base)
+	if !ok || base == "" {
+		return "", "", "", false
+	}
+	sub, rest, ok = strings.Cut(after, `"`)
+	if !ok || rest != "" && rest[0] != '.' {
+		return "", "", "", false
+	}
+	return base, sub, rest, true
+}
+
+// matchSection reports whether the section path name matches pattern, in
+// which each asterisk matches any sequence of characters, and returns the part
+// of name matched by the first asterisk. For example, the pattern "program:*"
+// matches "program:web", returning "web". A pattern without an asterisk only
+// matches itself. If fold is true, letters are compared case-insensitively.
+func matchSection(pattern, name string, fold bool) (string, bool) {
+	prefix, rest, ok := strings.Cut(pattern, "*")
+	if !ok {
+		return "", equalName(pattern, name, fold)
+	}
+	if !hasPrefix(name, prefix, fold) {
+		return "", false
+	}
+	name = name[len(prefix):]
+	for i := len(name); i >= 0; i-- {
+		if _, ok := matchSection(rest, name[i:], fold); ok {
+			return name[:i], true
+		}
+	}
+	return "", false
+}
+
+// equalName reports whether the names a and b are equal, ignoring case if fold
+// is true.
+func equalName(a, b string, fold bool) bool {
+	if fold {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// hasPrefix reports whether the name s begins with prefix, ignoring case if
+// fold is true.
+func hasPrefix(s, prefix string, fold bool) bool {
+	return len(s) >= len(prefix) && equalName(s[:len(prefix)], prefix, fold)
+}
+
+// remove removes s from p, reporting whether it was found. Comments preceding
+// s that are separated from its header by a blank line, such as a file header
+// comment, do not belong to s and are kept before the element following it.
+func (p *parseTree) remove(s *section) bool {
+	i := slices.Index(p.sections, s)
+	if i < 0 {
+		return false
+	}
+	p.sections = slices.Delete(p.sections, i, i+1)
+	if detached := detachedComments(s.raw.leading); detached != "" {
+		if i < len(p.sections) {
+			p.sections[i].raw.leading = joinLeading(detached, p.sections[i].raw.leading)
+		} else {
+			p.trailing = joinLeading(detached, p.trailing)
+		}
+	}
+	return true
+}
+
+// detachedComments returns the lines of leading, the source text preceding an
+// element, up to and including the last blank line, if they contain a
+// comment. Otherwise it returns an empty string.
+func detachedComments(leading string) string {
+	lines := strings.SplitAfter(leading, "\n")
+	last := -1
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" && strings.HasSuffix(line, "\n") {
+			last = i
+		}
+	}
+	for _, line := range lines[:last+1] {
+		if strings.TrimSpace(line) != "" {
+			return strings.Join(lines[:last+1], "")
+		}
+	}
+	return ""
+}
+
+// joinLeading returns detached, as returned by detachedComments, followed by
+// leading, dropping the blank line that ends detached if leading already
+// begins with one.
+func joinLeading(detached, leading string) string {
+	first, _, _ := strings.Cut(leading, "\n")
+	if strings.Contains(leading, "\n") && strings.TrimSpace(first) == "" {
+		i := strings.LastIndexByte(strings.TrimSuffix(detached, "\n"), '\n')
+		detached = detached[:i+1]
+	}
+	return detached + leading
+}
+
+// newline returns the line ending used by p, defaulting to "\n".
+func (p *parseTree) newline() string {
+	if nl := p.global.newline(); nl != "\n" {
+		return nl
+	}
+	for _, s := range p.sections {
+		if nl := s.newline(); nl != "\n" {
+			return nl
+		}
+	}
+	return "\n"
+}
+
+// terminate appends a line ending to the source text of p, unless it is empty
+// or already ends with one.
+func (p *parseTree) terminate() {
+	nl := p.newline()
+	if p.trailing != "" {
+		p.trailing = terminate(p.trailing, nl)
+		return
+	}
+	last := p.global
+	if len(p.sections) > 0 {
+		last = p.sections[len(p.sections)-1]
+	}
+	switch {
+	case len(last.props) > 0:
+		last.props[len(last.props)-1].raw.trailing = terminate(last.props[len(last.props)-1].raw.trailing, nl)
+	case last.raw.header != "":
+		last.raw.trailing = terminate(last.raw.trailing, nl)
+	}
+}
+
+// write writes the source text of p to buf.
+func (p *parseTree) write(buf *bytes.Buffer) {
+	p.global.write(buf)
+	for _, s := range p.sections {
+		s.write(buf)
+	}
+	buf.WriteString(p.trailing)
+}
+
+// A section holds the properties of a single section in the order they appear
+// in the source. A property key that is repeated, or that has more than one
+// subkey, is stored once for each assignment.
 type section struct {
 	name  string
-	props map[string]property
+	props []property
+	pos   Position // the position of the header
+	raw   rawSection
+	fold  bool  // property keys are compared case-insensitively
+	err   error // the first edit rejected for an invalid name
 }
 
-func newSection(name string) section {
-	return section{
+// A rawSection holds the source text surrounding a section header.
+type rawSection struct {
+	leading  string // comments and blank lines preceding the header
+	header   string // the header, including its brackets
+	trailing string // the remainder of the header line, including the line ending
+}
+
+func newSection(name string) *section {
+	s := section{
 		name:  name,
-		props: make(map[string]property),
+		props: make([]property, 0),
 	}
+	if name != "" {
+		s.raw.header = string(sectionStart) + name + string(sectionEnd)
+	}
+	return &s
 }
 
 func (s *section) add(p property) {
-	s.props[p.key] = p
+	s.props = append(s.props, p)
 }
 
-func (s *section) get(key string) (*property, error) {
-	if key == "" {
-		return nil, &invalidKeyErr{"property key cannot be empty"}
+// fail records err as the error of s, unless an earlier error is recorded.
+func (s *section) fail(err error) {
+	if s.err == nil {
+		s.err = err
 	}
-	prop, ok := s.props[key]
-	if !ok {
-		prop = newProperty(key)
-		s.props[key] = prop
+}
+
+// setName renames s, rewriting its header. The global section, which has no
+// header, cannot be renamed. An invalid name is recorded as the error of s.
+func (s *section) setName(name string) {
+	if s.raw.header == "" {
+		return
 	}
-	return &prop, nil
+	if err := checkName("section", name); err != nil {
+		s.fail(err)
+		return
+	}
+	s.name = name
+	s.raw.header = string(sectionStart) + name + string(sectionEnd)
 }
 
-type property struct {
-	key  string
-	vals map[string][]string
+// insert inserts p into s at index i, terminating the lines on either side of
+// it if necessary.
+func (s *section) insert(i int, p property) {
+	nl := s.newline()
+	if i > 0 {
+		s.props[i-1].raw.trailing = terminate(s.props[i-1].raw.trailing, nl)
+	} else if s.raw.header != "" {
+		s.raw.trailing = terminate(s.raw.trailing, nl)
+	}
+	p.raw.trailing = terminate(p.raw.trailing, nl)
+	s.props = slices.Insert(s.props, i, p)
 }
 
-func newProperty(key string) property {
-	return property{
-		key:  key,
-		vals: make(map[string][]string),
+// remove removes every property assigned to key from s, returning the removed
+// properties in source order. Comments separated from a removed property by a
+// blank line, such as a file header, are kept in s: they are moved to precede
+// the next remaining property, or to follow the last one.
+func (s *section) remove(key string) []property {
+	removed := make([]property, 0)
+	props := make([]property, 0, len(s.props))
+	var detached string
+	for _, p := range s.props {
+		if p.key == key {
+			if d := detachedComments(p.raw.leading); d != "" {
+				detached += d
+				p.raw.leading = strings.TrimPrefix(p.raw.leading, d)
+			}
+			removed = append(removed, p)
+			continue
+		}
+		if detached != "" {
+			p.raw.leading = joinLeading(detached, p.raw.leading)
+			detached = ""
+		}
+		props = append(props, p)
 	}
+	if detached != "" {
+		if len(props) > 0 {
+			props[len(props)-1].raw.trailing += detached
+		} else {
+			s.raw.trailing += detached
+		}
+	}
+	s.props = props
+	return removed
 }
 
-func (p *property) add(key, value string) {
-	vals, ok := p.vals[key]
-	if !ok {
-		vals = make([]string, 0)
+// end returns the index following the last property assigned to key, or the
+// number of properties in s if key is not assigned.
+func (s *section) end(key string) int {
+	for i := len(s.props) - 1; i >= 0; i-- {
+		if s.props[i].key == key {
+			return i + 1
+		}
 	}
-	vals = append(vals, value)
-	p.vals[key] = vals
+	return len(s.props)
 }
 
-func (p *property) get(key string) []string {
-	vals, ok := p.vals[key]
-	if !ok {
-		vals = make([]string, 0)
-		p.vals[key] = vals
+// newline returns the line ending used by s, defaulting to "\n".
+func (s *section) newline() string {
+	if strings.HasSuffix(s.raw.trailing, "\r\n") {
+		return "\r\n"
+	}
+	for _, p := range s.props {
+		if strings.HasSuffix(p.raw.trailing, "\r\n") {
+			return "\r\n"
+		}
+	}
+	return "\n"
+}
+
+// write writes the source text of s to buf.
+func (s *section) write(buf *bytes.Buffer) {
+	buf.WriteString(s.raw.leading)
+	buf.WriteString(s.raw.header)
+	buf.WriteString(s.raw.trailing)
+	for _, p := range s.props {
+		p.write(buf)
+	}
+}
+
+// get returns every value assigned to subkey of the property key named key, in
+// source order.
+func (s *section) get(key, subkey string) []string {
+	vals := make([]string, 0)
+	for _, p := range s.props {
+		if equalName(p.key, key, s.fold) && p.subkey == subkey {
+			vals = append(vals, p.val)
+		}
 	}
 	return vals
 }
+
+// has reports whether the property key named key is assigned in s.
+func (s *section) has(key string) bool {
+	for _, p := range s.props {
+		if equalName(p.key, key, s.fold) {
+			return true
+		}
+	}
+	return false
+}
+
+// keys returns the name of each property key in s, in the order each first
+// appears.
+func (s *section) keys() []string {
+	keys := make([]string, 0)
+	for _, p := range s.props {
+		if !slices.ContainsFunc(keys, func(k string) bool { return equalName(k, p.key, s.fold) }) {
+			keys = append(keys, p.key)
+		}
+	}
+	return keys
+}
+
+// subkeys returns the name of each subkey of the property key named key, in
+// the order each first appears.
+func (s *section) subkeys(key string) []string {
+	subkeys := make([]string, 0)
+	for _, p := range s.props {
+		if equalName(p.key, key, s.fold) && p.subkey != "" && !slices.Contains(subkeys, p.subkey) {
+			subkeys = append(subkeys, p.subkey)
+		}
+	}
+	return subkeys
+}
+
+// A property is a single assignment of a value to a property key, or to a
+// subkey of a property key.
+type property struct {
+	key    string
+	subkey string
+	val    string
+	pos    Position // the position of the key
+	raw    rawProperty
+}
+
+// A rawProperty holds the source text surrounding a property.
+type rawProperty struct {
+	leading  string // comments, blank lines and indentation preceding the key
+	subkey   string // the subkey, including its brackets
+	sep      string // the assignment character and any surrounding whitespace
+	val      string // the value as written in the source
+	trailing string // the remainder of the line, including the line ending
+}
+
+func newProperty(key, subkey, val, newline string) property {
+	p := property{
+		key:    key,
+		subkey: subkey,
+		val:    val,
+		raw: rawProperty{
+			sep:      string(assignment),
+			val:      quoteValue(val),
+			trailing: newline,
+		},
+	}
+	if subkey != "" {
+		p.raw.subkey = string(mapKeyStart) + subkey + string(mapKeyEnd)
+	}
+	return p
+}
+
+// setValue sets the value of p to val, quoting it in the source text if
+// necessary.
+func (p *property) setValue(val string) {
+	p.val = val
+	p.raw.val = quoteValue(val)
+}
+
+// write writes the source text of p to buf.
+func (p *property) write(buf *bytes.Buffer) {
+	buf.WriteString(p.raw.leading)
+	buf.WriteString(p.key)
+	buf.WriteString(p.raw.subkey)
+	buf.WriteString(p.raw.sep)
+	buf.WriteString(p.raw.val)
+	buf.WriteString(p.raw.trailing)
+}
+
+// terminate returns s with newline appended, unless s already ends with a line
+// ending.
+func terminate(s, newline string) string {
+	if strings.HasSuffix(s, "\n") {
+		return s
+	}
+	return s + newline
+}
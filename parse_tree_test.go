@@ -8,74 +8,60 @@ import (
 	"github.com/google/go-cmp/cmp"
 )
 
+// ignoreSource ignores the source text and positions retained by parse tree
+// elements, so that their values alone can be compared.
+var ignoreSource = cmp.Options{
+	cmpopts.IgnoreFields(parseTree{}, "trailing"),
+	cmpopts.IgnoreFields(section{}, "pos", "raw"),
+	cmpopts.IgnoreFields(property{}, "pos", "raw"),
+}
+
 func TestParseTreeAdd(t *testing.T) {
 	tests := []struct {
-		sections []section
+		sections []*section
 		want     parseTree
 	}{
 		{
-			sections: []section{},
+			sections: []*section{},
 			want: parseTree{
-				global: section{
+				global: &section{
 					name:  "",
-					props: make(map[string]property),
+					props: []property{},
 				},
-				sections: map[string][]section{},
+				sections: []*section{},
 			},
 		},
 		{
-			sections: []section{
+			sections: []*section{
 				{
 					name: "user",
-					props: map[string]property{
-						"shell": {
-							key: "shell",
-							vals: map[string][]string{
-								"": {"/bin/bash"},
-							},
-						},
+					props: []property{
+						{key: "shell", val: "/bin/bash"},
 					},
 				},
 				{
 					name: "user",
-					props: map[string]property{
-						"shell": {
-							key: "shell",
-							vals: map[string][]string{
-								"": {"/bin/zsh"},
-							},
-						},
+					props: []property{
+						{key: "shell", val: "/bin/zsh"},
 					},
 				},
 			},
 			want: parseTree{
-				global: section{
+				global: &section{
 					name:  "",
-					props: map[string]property{},
+					props: []property{},
 				},
-				sections: map[string][]section{
-					"user": {
-						{
-							name: "user",
-							props: map[string]property{
-								"shell": {
-									key: "shell",
-									vals: map[string][]string{
-										"": {"/bin/bash"},
-									},
-								},
-							},
+				sections: []*section{
+					{
+						name: "user",
+						props: []property{
+							{key: "shell", val: "/bin/bash"},
 						},
-						{
-							name: "user",
-							props: map[string]property{
-								"shell": {
-									key: "shell",
-									vals: map[string][]string{
-										"": {"/bin/zsh"},
-									},
-								},
-							},
+					},
+					{
+						name: "user",
+						props: []property{
+							{key: "shell", val: "/bin/zsh"},
 						},
 					},
 				},
@@ -90,7 +76,7 @@ func TestParseTreeAdd(t *testing.T) {
 			got.add(s)
 		}
 
-		if !cmp.Equal(got, test.want, cmp.Options{cmp.AllowUnexported(property{}, section{}, parseTree{})}) {
+		if !cmp.Equal(got, test.want, cmp.Options{cmp.AllowUnexported(property{}, section{}, parseTree{}), ignoreSource}) {
 			t.Errorf("%+v != %+v", got, test.want)
 		}
 	}
@@ -99,66 +85,58 @@ func TestParseTreeAdd(t *testing.T) {
 func TestParseTreeGet(t *testing.T) {
 	tree := parseTree{
 		global: newSection(""),
-		sections: map[string][]section{
-			"user": {
-				{
-					name: "user",
-					props: map[string]property{
-						"shell": {
-							key: "shell",
-							vals: map[string][]string{
-								"": {"/bin/bash"},
-							},
-						},
-					},
+		sections: []*section{
+			{
+				name: "user",
+				props: []property{
+					{key: "shell", val: "/bin/bash"},
 				},
 			},
-			"root": {
-				{
-					name: "root",
-					props: map[string]property{
-						"username": {
-							key: "username",
-							vals: map[string][]string{
-								"": {"root"},
-							},
-						},
-					},
+			{
+				name: "root",
+				props: []property{
+					{key: "username", val: "root"},
 				},
 			},
-			"admin": {
-				{
-					name: "admin",
-					props: map[string]property{
-						"username": {
-							key: "username",
-							vals: map[string][]string{
-								"": {"admin"},
-							},
-						},
-					},
+			{
+				name: "admin",
+				props: []property{
+					{key: "username", val: "admin"},
+				},
+			},
+			{
+				name: "user",
+				props: []property{
+					{key: "shell", val: "/bin/zsh"},
+				},
+			},
+			{
+				name: `user "root"`,
+				props: []property{
+					{key: "shell", val: "/bin/sh"},
 				},
 			},
 		},
 	}
 	tests := []struct {
 		input       string
-		want        []section
+		want        []*section
 		shouldError bool
 		wantError   error
 	}{
 		{
 			input: "user",
-			want: []section{
+			want: []*section{
 				{
 					name: "user",
-					props: map[string]property{
-						"shell": {
-							key: "shell",
-							vals: map[string][]string{
-								"": {"/bin/bash"},
-							},
-						},
+					props: []property{
+						{key: "shell", val: "/bin/bash"},
+					},
+				},
+				{
+					name: "user",
+					props: []property{
+						{key: "shell", val: "/bin/zsh"},
 					},
 				},
 			},
@@ -171,38 +149,46 @@ func TestParseTreeGet(t *testing.T) {
 		},
 		{
 			input: "*",
-			want: []section{
+			want: []*section{
 				{
 					name: "user",
-					props: map[string]property{
-						"shell": {
-							key: "shell",
-							vals: map[string][]string{
-								"": {"/bin/bash"},
-							},
-						},
+					props: []property{
+						{key: "shell", val: "/bin/bash"},
 					},
 				},
 				{
 					name: "root",
-					props: map[string]property{
-						"username": {
-							key: "username",
-							vals: map[string][]string{
-								"": {"root"},
-							},
-						},
+					props: []property{
+						{key: "username", val: "root"},
 					},
 				},
 				{
 					name: "admin",
-					props: map[string]property{
-						"username": {
-							key: "username",
-							vals: map[string][]string{
-								"": {"admin"},
-							},
-						},
+					props: []property{
+						{key: "username", val: "admin"},
+					},
+				},
+				{
+					name: "user",
+					props: []property{
+						{key: "shell", val: "/bin/zsh"},
+					},
+				},
+				{
+					name: `user "root"`,
+					props: []property{
+						{key: "shell", val: "/bin/sh"},
+					},
+				},
+			},
+		},
+		{
+			input: "user.root",
+			want: []*section{
+				{
+					name: `user "root"`,
+					props: []property{
+						{key: "shell", val: "/bin/sh"},
 					},
 				},
 			},
@@ -226,7 +212,7 @@ func TestParseTreeGet(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			if !cmp.Equal(got, test.want, cmp.Options{cmp.AllowUnexported(property{}, section{}), cmpopts.SortSlices(func(a, b section) bool { return a.name > b.name })}) {
+			if !cmp.Equal(got, test.want, cmp.Options{cmp.AllowUnexported(property{}, section{}), ignoreSource}) {
 				t.Errorf("%+v != %+v", got, test.want)
 			}
 		}
@@ -237,51 +223,23 @@ func TestSectionAdd(t *testing.T) {
 	tests := []struct {
 		name  string
 		props []property
-		want  section
+		want  *section
 	}{
 		{
 			name: "user",
 			props: []property{
-				{
-					key: "name",
-					vals: map[string][]string{
-						"": {"root"},
-					},
-				},
-				{
-					key: "shell",
-					vals: map[string][]string{
-						"": {"/bin/bash"},
-					},
-				},
-				{
-					key: "uid",
-					vals: map[string][]string{
-						"": {"1000", "1001"},
-					},
-				},
+				{key: "name", val: "root"},
+				{key: "uid", val: "1000"},
+				{key: "shell", val: "/bin/bash"},
+				{key: "uid", val: "1001"},
 			},
-			want: section{
+			want: &section{
 				name: "user",
-				props: map[string]property{
-					"name": {
-						key: "name",
-						vals: map[string][]string{
-							"": {"root"},
-						},
-					},
-					"shell": {
-						key: "shell",
-						vals: map[string][]string{
-							"": {"/bin/bash"},
-						},
-					},
-					"uid": {
-						key: "uid",
-						vals: map[string][]string{
-							"": {"1000", "1001"},
-						},
-					},
+				props: []property{
+					{key: "name", val: "root"},
+					{key: "uid", val: "1000"},
+					{key: "shell", val: "/bin/bash"},
+					{key: "uid", val: "1001"},
 				},
 			},
 		},
@@ -294,7 +252,7 @@ func TestSectionAdd(t *testing.T) {
 			got.add(p)
 		}
 
-		if !cmp.Equal(got, test.want, cmp.Options{cmp.AllowUnexported(property{}, section{})}) {
+		if !cmp.Equal(got, test.want, cmp.Options{cmp.AllowUnexported(property{}, section{}), ignoreSource}) {
 			t.Errorf("%v != %v", got, test.want)
 		}
 	}
@@ -303,176 +261,179 @@ func TestSectionAdd(t *testing.T) {
 func TestSectionGet(t *testing.T) {
 	sec := section{
 		name: "user",
-		props: map[string]property{
-			"shell": {
-				key: "shell",
-				vals: map[string][]string{
-					"":      {"/bin/bash"},
-					"win32": {"PowerShell.exe"},
-				},
-			},
-			"username": {
-				key: "username",
-				vals: map[string][]string{
-					"": {"root"},
-				},
-			},
+		props: []property{
+			{key: "shell", val: "/bin/bash"},
+			{key: "shell", subkey: "win32", val: "PowerShell.exe"},
+			{key: "username", val: "root"},
+			{key: "shell", val: "/bin/zsh"},
 		},
 	}
 	tests := []struct {
-		input       string
-		want        *property
-		shouldError bool
-		wantError   error
+		desc   string
+		key    string
+		subkey string
+		want   []string
 	}{
 		{
-			input: "shell",
-			want: &property{
-				key: "shell",
-				vals: map[string][]string{
-					"":      {"/bin/bash"},
-					"win32": {"PowerShell.exe"},
-				},
-			},
+			desc: "simple",
+			key:  "username",
+			want: []string{"root"},
 		},
 		{
-			input: "uid",
-			want: &property{
-				key:  "uid",
-				vals: map[string][]string{},
-			},
+			desc: "duplicate",
+			key:  "shell",
+			want: []string{"/bin/bash", "/bin/zsh"},
 		},
 		{
-			input:       "",
-			want:        nil,
-			shouldError: true,
-			wantError:   &invalidKeyErr{"property key cannot be empty"},
+			desc:   "subkey",
+			key:    "shell",
+			subkey: "win32",
+			want:   []string{"PowerShell.exe"},
+		},
+		{
+			desc: "missing",
+			key:  "uid",
+			want: []string{},
 		},
 	}
 
 	for _, test := range tests {
-		got, err := sec.get(test.input)
+		t.Run(test.desc, func(t *testing.T) {
+			got := sec.get(test.key, test.subkey)
 
-		if test.shouldError {
-			if !cmp.Equal(err, test.wantError, cmpopts.IgnoreUnexported(invalidKeyErr{})) {
-				t.Errorf("%v != %v", err, test.wantError)
-			}
-		} else {
-			if err != nil {
-				t.Fatal(err)
-			}
-			if !cmp.Equal(got, test.want, cmp.Options{cmp.AllowUnexported(property{}, section{})}) {
+			if !cmp.Equal(got, test.want) {
 				t.Errorf("%v != %v", got, test.want)
 			}
-		}
+		})
 	}
 }
 
-func TestPropertyAdd(t *testing.T) {
-	tests := []struct {
-		key    string
-		values map[string][]string
-		want   property
-	}{
-		{
-			key: "shell",
-			values: map[string][]string{
-				"": {"/bin/bash", "/bin/zsh"},
-			},
-			want: property{
-				key: "shell",
-				vals: map[string][]string{
-					"": {"/bin/bash", "/bin/zsh"},
-				},
-			},
-		},
-		{
-			key: "Greeting",
-			values: map[string][]string{
-				"en": {"Hello"},
-				"fr": {"Bonjour"},
-			},
-			want: property{
-				key: "Greeting",
-				vals: map[string][]string{
-					"en": {"Hello"},
-					"fr": {"Bonjour"},
-				},
-			},
+func TestSectionKeys(t *testing.T) {
+	sec := section{
+		name: "user",
+		props: []property{
+			{key: "username", val: "root"},
+			{key: "shell", subkey: "win32", val: "PowerShell.exe"},
+			{key: "group", val: "wheel"},
+			{key: "shell", subkey: "unix", val: "/bin/bash"},
+			{key: "group", val: "video"},
 		},
 	}
 
-	for _, test := range tests {
-		got := newProperty(test.key)
-
-		for k, v := range test.values {
-			for _, vv := range v {
-				got.add(k, vv)
-			}
-		}
+	want := []string{"username", "shell", "group"}
+	if got := sec.keys(); !cmp.Equal(got, want) {
+		t.Errorf("%v != %v", got, want)
+	}
 
-		if !cmp.Equal(got, test.want, cmp.Options{cmp.AllowUnexported(property{})}) {
-			t.Errorf("%v != %v", got, test.want)
-		}
+	want = []string{"win32", "unix"}
+	if got := sec.subkeys("shell"); !cmp.Equal(got, want) {
+		t.Errorf("%v != %v", got, want)
 	}
-}
 
-func TestPropertyGet(t *testing.T) {
-	prop := property{
-		key: "shell",
-		vals: map[string][]string{
-			"":      {"/bin/bash"},
-			"win32": {"PowerShell.exe"},
-		},
+	if !sec.has("group") {
+		t.Errorf("has(%v) = false, want true", "group")
+	}
+	if sec.has("uid") {
+		t.Errorf("has(%v) = true, want false", "uid")
 	}
+}
 
+func TestInvalidKeyErr(t *testing.T) {
 	tests := []struct {
-		desc  string
-		input string
-		want  []string
+		input invalidKeyErr
+		want  string
 	}{
 		{
-			desc:  "simple",
-			input: "",
-			want:  []string{"/bin/bash"},
-		},
-		{
-			desc:  "subkey",
-			input: "win32",
-			want:  []string{"PowerShell.exe"},
-		},
-		{
-			desc:  "create",
-			input: "haiku",
-			want:  []string{},
+			input: invalidKeyErr{"name cannot be empty"},
+			want:  "invalid key: name cannot be empty",
 		},
 	}
 
 	for _, test := range tests {
-		got := prop.get(test.input)
+		got := test.input.Error()
 
-		if !cmp.Equal(got, test.want) {
+		if got != test.want {
 			t.Errorf("%v != %v", got, test.want)
 		}
 	}
 }
 
-func TestInvalidKeyErr(t *testing.T) {
+func TestSectionPath(t *testing.T) {
 	tests := []struct {
-		input invalidKeyErr
+		input string
 		want  string
 	}{
-		{
-			input: invalidKeyErr{"name cannot be empty"},
-			want:  "invalid key: name cannot be empty",
-		},
+		{input: "server", want: "server"},
+		{input: "server.tls", want: "server.tls"},
+		{input: `server "tls"`, want: "server.tls"},
+		{input: `server.tls "ca"`, want: "server.tls.ca"},
+		{input: `"tls"`, want: `"tls"`},
+		{input: `server "tls`, want: `server "tls`},
+		{input: `branch "v1.2"`, want: "branch.v1.2"},
+		{input: `remote "origin".tls`, want: "remote.origin.tls"},
+		{input: `remote "origin" tls`, want: `remote "origin" tls`},
 	}
 
 	for _, test := range tests {
-		got := test.input.Error()
+		if got := sectionPath(test.input); got != test.want {
+			t.Errorf("sectionPath(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+}
 
-		if got != test.want {
-			t.Errorf("%v != %v", got, test.want)
+func TestMatchSection(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		name      string
+		fold      bool
+		want      string
+		wantMatch bool
+	}{
+		{pattern: "user", name: "user", wantMatch: true},
+		{pattern: "user", name: "users"},
+		{pattern: "*", name: "user", want: "user", wantMatch: true},
+		{pattern: "program:*", name: "program:web", want: "web", wantMatch: true},
+		{pattern: "program:*", name: "group:web"},
+		{pattern: "profile *", name: "profile dev", want: "dev", wantMatch: true},
+		{pattern: "*.tls", name: "server.tls", want: "server", wantMatch: true},
+		{pattern: "*.*", name: "server.tls.ca", want: "server.tls", wantMatch: true},
+		{pattern: "program:*", name: "program:", want: "", wantMatch: true},
+		{pattern: "User", name: "user"},
+		{pattern: "User", name: "user", fold: true, wantMatch: true},
+		{pattern: "Program:*", name: "program:Web", fold: true, want: "Web", wantMatch: true},
+	}
+
+	for _, test := range tests {
+		got, ok := matchSection(test.pattern, test.name, test.fold)
+		if got != test.want || ok != test.wantMatch {
+			t.Errorf("matchSection(%q, %q) = %q, %v, want %q, %v", test.pattern, test.name, got, ok, test.want, test.wantMatch)
 		}
 	}
 }
+
+func TestSectionFold(t *testing.T) {
+	sec := section{
+		name: "user",
+		props: []property{
+			{key: "Shell", val: "/bin/bash"},
+			{key: "shell", subkey: "win32", val: "PowerShell.exe"},
+			{key: "SHELL", val: "/bin/zsh"},
+		},
+		fold: true,
+	}
+
+	want := []string{"/bin/bash", "/bin/zsh"}
+	if got := sec.get("shell", ""); !cmp.Equal(got, want) {
+		t.Errorf("%v != %v", got, want)
+	}
+
+	want = []string{"Shell"}
+	if got := sec.keys(); !cmp.Equal(got, want) {
+		t.Errorf("%v != %v", got, want)
+	}
+
+	want = []string{"win32"}
+	if got := sec.subkeys("SHELL"); !cmp.Equal(got, want) {
+		t.Errorf("%v != %v", got, want)
+	}
+}
@@ -0,0 +1,65 @@
+package ini
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// quoteValue returns s, enclosed in double quotes and escaped by quote if it
+// would otherwise be read back as a different value.
+func quoteValue(s string) string {
+	if needsQuote(s) {
+		return quote(s)
+	}
+	return s
+}
+
+// needsQuote reports whether the value s must be quoted to be read back as
+// written: if it is empty, which is only read with AllowEmptyValues; begins or
+// ends with whitespace, which is trimmed; begins with a quote; ends with a
+// backslash, which may continue the value on the next line; or contains a
+// control character, such as a newline, or a comment character that other
+// readers may take to begin a comment.
+func needsQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	first, _ := utf8.DecodeRuneInString(s)
+	last, _ := utf8.DecodeLastRuneInString(s)
+	if unicode.IsSpace(first) || unicode.IsSpace(last) || first == doubleQuote || first == singleQuote || last == escape {
+		return true
+	}
+	return strings.ContainsFunc(s, func(r rune) bool {
+		return r == comment || r == numberSign || unicode.IsControl(r) && r != tab
+	})
+}
+
+// quote returns s enclosed in double quotes, escaping backslashes, double
+// quotes and control characters.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteRune(doubleQuote)
+	for _, r := range s {
+		switch r {
+		case doubleQuote, escape:
+			b.WriteRune(escape)
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if unicode.IsControl(r) {
+				fmt.Fprintf(&b, `\u%04x`, r)
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+	b.WriteRune(doubleQuote)
+	return b.String()
+}
@@ -0,0 +1,45 @@
+package ini
+
+import "testing"
+
+func TestQuoteValue(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: "", want: `""`},
+		{input: "/bin/bash", want: "/bin/bash"},
+		{input: "say \"hi\"", want: "say \"hi\""},
+		{input: `C:\Windows`, want: `C:\Windows`},
+		{input: "tab\tinside", want: "tab\tinside"},
+		{input: "é", want: "é"},
+		{input: " padded ", want: `" padded "`},
+		{input: "\tindented", want: `"\tindented"`},
+		{input: `"quoted"`, want: `"\"quoted\""`},
+		{input: "'quoted'", want: `"'quoted'"`},
+		{input: `C:\`, want: `"C:\\"`},
+		{input: "a;b", want: `"a;b"`},
+		{input: "#1", want: `"#1"`},
+		{input: "line\nbreak\r\n", want: `"line\nbreak\r\n"`},
+		{input: "bell\a", want: `"bell\u0007"`},
+	}
+
+	for _, test := range tests {
+		if got := quoteValue(test.input); got != test.want {
+			t.Errorf("quoteValue(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+}
+
+func TestQuoteRoundTrip(t *testing.T) {
+	inputs := []string{"", " padded ", `"quoted"`, "'quoted'", `C:\`, "a;b", "line\nbreak\r\n", "bell\a", "é\\\"\t"}
+
+	for _, input := range inputs {
+		l := lex("key=" + quoteValue(input))
+		l.nextToken()
+		l.nextToken()
+		if tok := l.nextToken(); tok.typ != TokenValue || tok.val != input {
+			t.Errorf("nextToken() = %v %q, want %v %q", tok.typ, tok.val, TokenValue, input)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package ini
+
+import (
+	"bufio"
+	"io"
+)
+
+// A Token is a lexical token of INI source text.
+type Token struct {
+	Type TokenType    // the type of the token
+	Pos  Position     // the position of the first character of Raw
+	Val  string       // the value of the token, without delimiters such as brackets
+	Raw  string       // the source text of the token
+	Err  *SyntaxError // the syntax error described by a TokenError
+}
+
+// A Scanner reads INI source text and splits it into lexical tokens, in the
+// order they appear. Whitespace, blank lines and line endings between tokens
+// are skipped; they can be recovered from the positions of the tokens.
+//
+// A Scanner reports syntax errors as tokens of type TokenError. Unless
+// Options.ContinueOnError is set, scanning stops at the first syntax error.
+type Scanner struct {
+	l    *lexer
+	tok  Token
+	err  error
+	done bool
+}
+
+// NewScanner returns a new Scanner that reads from r. If r has a Name method,
+// as an *os.File does, positions refer to the input by that name.
+func NewScanner(r io.Reader) *Scanner {
+	l := lexReader(bufio.NewReader(r))
+	if n, ok := r.(interface{ Name() string }); ok {
+		l.name = n.Name()
+	}
+	return &Scanner{l: l}
+}
+
+// SetOptions allows scanning behavior to be configured with an Options value.
+// It must be called before the first call to Scan.
+func (s *Scanner) SetOptions(opts Options) {
+	s.l.opts = opts.lexerOptions()
+}
+
+// Scan advances the Scanner to the next token, which is then available
+// through the Token method. It returns false when scanning stops, either by
+// reaching the end of the input or at an error. After Scan returns false, the
+// Err method returns any error that occurred during scanning.
+func (s *Scanner) Scan() bool {
+	if s.done {
+		return false
+	}
+	tok := s.l.nextToken()
+	if s.l.err != nil {
+		s.err = s.l.err
+		s.done = true
+		return false
+	}
+	s.tok = Token{
+		Type: tok.typ,
+		Pos:  tok.pos,
+		Val:  tok.val,
+		Raw:  tok.raw,
+		Err:  tok.err,
+	}
+	switch {
+	case tok.typ == TokenEOF:
+		s.done = true
+		return false
+	case tok.typ == TokenError && !s.l.opts.continueOnError:
+		s.err = tok.err
+		s.done = true
+	}
+	return true
+}
+
+// Token returns the token scanned by the most recent call to Scan.
+func (s *Scanner) Token() Token {
+	return s.tok
+}
+
+// Err returns the error that stopped scanning: a *SyntaxError if scanning
+// stopped at a syntax error, or the error returned by the underlying reader.
+// It returns nil if scanning reached the end of the input.
+func (s *Scanner) Err() error {
+	return s.err
+}
@@ -0,0 +1,115 @@
+package ini
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestScanner(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		opts        Options
+		want        []Token
+		wantError   error
+	}{
+		{
+			description: "complete case",
+			input:       "; user\n[user]\r\n  shell[ü] = /bin/bash\n",
+			want: []Token{
+				{Type: TokenComment, Pos: Position{Offset: 0, Line: 1, Column: 1}, Val: "; user", Raw: "; user"},
+				{Type: TokenSection, Pos: Position{Offset: 7, Line: 2, Column: 1}, Val: "user", Raw: "[user]"},
+				{Type: TokenKey, Pos: Position{Offset: 17, Line: 3, Column: 3}, Val: "shell", Raw: "shell"},
+				{Type: TokenSubkey, Pos: Position{Offset: 22, Line: 3, Column: 8}, Val: "ü", Raw: "[ü]"},
+				{Type: TokenAssignment, Pos: Position{Offset: 27, Line: 3, Column: 12}, Val: "=", Raw: "="},
+				{Type: TokenValue, Pos: Position{Offset: 29, Line: 3, Column: 14}, Val: "/bin/bash", Raw: "/bin/bash"},
+			},
+		},
+		{
+			description: "keep whitespace",
+			input:       "[ user ]\nshell = /bin/bash \n",
+			opts:        Options{KeepWhitespace: true},
+			want: []Token{
+				{Type: TokenSection, Pos: Position{Offset: 0, Line: 1, Column: 1}, Val: " user ", Raw: "[ user ]"},
+				{Type: TokenKey, Pos: Position{Offset: 9, Line: 2, Column: 1}, Val: "shell ", Raw: "shell "},
+				{Type: TokenAssignment, Pos: Position{Offset: 15, Line: 2, Column: 7}, Val: "=", Raw: "="},
+				{Type: TokenValue, Pos: Position{Offset: 16, Line: 2, Column: 8}, Val: " /bin/bash ", Raw: " /bin/bash "},
+			},
+		},
+		{
+			description: "options",
+			input:       "# user\nshell=/bin/bash\n  /bin/zsh",
+			opts:        Options{AllowNumberSignComments: true, AllowMultilineValues: true},
+			want: []Token{
+				{Type: TokenComment, Pos: Position{Offset: 0, Line: 1, Column: 1}, Val: "# user", Raw: "# user"},
+				{Type: TokenKey, Pos: Position{Offset: 7, Line: 2, Column: 1}, Val: "shell", Raw: "shell"},
+				{Type: TokenAssignment, Pos: Position{Offset: 12, Line: 2, Column: 6}, Val: "=", Raw: "="},
+				{Type: TokenValue, Pos: Position{Offset: 13, Line: 2, Column: 7}, Val: "/bin/bash\n  /bin/zsh", Raw: "/bin/bash\n  /bin/zsh"},
+			},
+		},
+		{
+			description: "error",
+			input:       "[user\nname=root\n",
+			want: []Token{
+				{Type: TokenError, Pos: Position{Offset: 5, Line: 1, Column: 6}, Val: `unexpected character: '\n', sections must be closed with a ']'`, Raw: "[user"},
+			},
+			wantError: &SyntaxError{Kind: UnclosedSection},
+		},
+		{
+			description: "continue on error",
+			input:       "[user\nname=root\n",
+			opts:        Options{ContinueOnError: true},
+			want: []Token{
+				{Type: TokenError, Pos: Position{Offset: 5, Line: 1, Column: 6}, Val: `unexpected character: '\n', sections must be closed with a ']'`, Raw: "[user"},
+				{Type: TokenKey, Pos: Position{Offset: 6, Line: 2, Column: 1}, Val: "name", Raw: "name"},
+				{Type: TokenAssignment, Pos: Position{Offset: 10, Line: 2, Column: 5}, Val: "=", Raw: "="},
+				{Type: TokenValue, Pos: Position{Offset: 11, Line: 2, Column: 6}, Val: "root", Raw: "root"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			s := NewScanner(iotest.OneByteReader(strings.NewReader(test.input)))
+			s.SetOptions(test.opts)
+
+			got := make([]Token, 0)
+			for s.Scan() {
+				got = append(got, s.Token())
+			}
+			if !cmp.Equal(got, test.want, cmpopts.IgnoreFields(Token{}, "Err")) {
+				t.Errorf("Scan() = %v, want %v\ndiff -want +got\n%v", got, test.want, cmp.Diff(test.want, got, cmpopts.IgnoreFields(Token{}, "Err")))
+			}
+			for _, tok := range got {
+				if (tok.Type == TokenError) != (tok.Err != nil) {
+					t.Errorf("Token() = %v with Err %v", tok.Type, tok.Err)
+				}
+			}
+			if !cmp.Equal(s.Err(), test.wantError, cmpopts.IgnoreFields(SyntaxError{}, "Pos", "Text", "Msg"), cmpopts.IgnoreUnexported(SyntaxError{})) {
+				t.Errorf("Err() = %v, want %v", s.Err(), test.wantError)
+			}
+		})
+	}
+}
+
+func TestScannerReadError(t *testing.T) {
+	want := errors.New("connection reset")
+	s := NewScanner(io.MultiReader(strings.NewReader("name=root\n"), iotest.ErrReader(want)))
+
+	got := make([]TokenType, 0)
+	for s.Scan() {
+		got = append(got, s.Token().Type)
+	}
+	if err := s.Err(); err != want {
+		t.Errorf("Err() = %v, want %v", err, want)
+	}
+	if wantTypes := []TokenType{TokenKey, TokenAssignment, TokenValue}; !cmp.Equal(got, wantTypes) {
+		t.Errorf("Scan() = %v, want %v", got, wantTypes)
+	}
+}
@@ -3,25 +3,85 @@ package ini
 import (
 	"reflect"
 	"strings"
+	"unicode"
 )
 
 type tag struct {
 	name      string
 	omitempty bool
+	layout    string // the time layout given by the "layout=" option
+	seconds   bool   // durations may be given as a plain number of seconds
+	required  bool   // the key or section must be present when decoding
+
+	defaultValue string // the value given by the "default=" option
+	hasDefault   bool   // whether the "default=" option is given
 }
 
 func newTag(sf reflect.StructField) tag {
+	return newTagWithOptions(sf, Options{})
+}
+
+// newTagWithOptions parses the tag of sf as newTag does, naming a field
+// without a tagged name by applying opts.FieldNaming, if set, to its name.
+func newTagWithOptions(sf reflect.StructField, opts Options) tag {
 	var t tag
-	st := strings.SplitN(sf.Tag.Get("ini"), ",", 2)
-	switch len(st) {
-	case 1:
-		t.name = st[0]
-		if t.name == "" {
-			t.name = sf.Name
+	st := strings.Split(sf.Tag.Get("ini"), ",")
+	t.name = st[0]
+	if t.name == "" {
+		t.name = sf.Name
+		if opts.FieldNaming != nil && sf.Name != "ININame" && sf.Name != "ININameMatch" {
+			t.name = opts.FieldNaming(sf.Name)
+		}
+	}
+	for i, opt := range st[1:] {
+		switch {
+		case strings.HasPrefix(opt, "default="):
+			// The default is the remainder of the tag, including any commas.
+			t.defaultValue = strings.TrimPrefix(strings.Join(st[i+1:], ","), "default=")
+			t.hasDefault = true
+			return t
+		case opt == "omitempty":
+			t.omitempty = true
+		case opt == "seconds":
+			t.seconds = true
+		case opt == "required":
+			t.required = true
+		case strings.HasPrefix(opt, "layout="):
+			t.layout = strings.TrimPrefix(opt, "layout=")
 		}
-	default:
-		t.name = st[0]
-		t.omitempty = strings.Contains(st[1], "omitempty")
 	}
 	return t
 }
+
+// SnakeCase returns name, a Go identifier such as "MaxRetries" or "HTTPPort",
+// converted to snake case, such as "max_retries" or "http_port". It can be
+// used as Options.FieldNaming.
+func SnakeCase(name string) string {
+	return delimit(name, '_')
+}
+
+// KebabCase returns name, a Go identifier such as "MaxRetries" or "HTTPPort",
+// converted to kebab case, such as "max-retries" or "http-port". It can be
+// used as Options.FieldNaming.
+func KebabCase(name string) string {
+	return delimit(name, '-')
+}
+
+// delimit returns name in lower case, with sep inserted at each word boundary:
+// before an upper case letter that follows a lower case letter or digit, or
+// that begins a word following an acronym, as the "P" of "HTTPPort" does.
+func delimit(name string, sep rune) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			next := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || unicode.IsUpper(prev) && next {
+				b.WriteRune(sep)
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
@@ -30,6 +30,69 @@ func TestNewTag(t *testing.T) {
 				omitempty: true,
 			},
 		},
+		{
+			input: reflect.StructField{
+				Name: "Field",
+				Tag:  reflect.StructTag(`ini:",omitempty"`),
+			},
+			want: tag{
+				name:      "Field",
+				omitempty: true,
+			},
+		},
+		{
+			input: reflect.StructField{
+				Name: "Expires",
+				Tag:  reflect.StructTag(`ini:"expires,omitempty,layout=2006-01-02 15:04"`),
+			},
+			want: tag{
+				name:      "expires",
+				omitempty: true,
+				layout:    "2006-01-02 15:04",
+			},
+		},
+		{
+			input: reflect.StructField{
+				Name: "Timeout",
+				Tag:  reflect.StructTag(`ini:"timeout,seconds"`),
+			},
+			want: tag{
+				name:    "timeout",
+				seconds: true,
+			},
+		},
+		{
+			input: reflect.StructField{
+				Name: "User",
+				Tag:  reflect.StructTag(`ini:"user,required"`),
+			},
+			want: tag{
+				name:     "user",
+				required: true,
+			},
+		},
+		{
+			input: reflect.StructField{
+				Name: "Hosts",
+				Tag:  reflect.StructTag(`ini:"host,omitempty,default=db1,db2"`),
+			},
+			want: tag{
+				name:         "host",
+				omitempty:    true,
+				defaultValue: "db1,db2",
+				hasDefault:   true,
+			},
+		},
+		{
+			input: reflect.StructField{
+				Name: "Name",
+				Tag:  reflect.StructTag(`ini:"name,default="`),
+			},
+			want: tag{
+				name:       "name",
+				hasDefault: true,
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -40,3 +103,70 @@ func TestNewTag(t *testing.T) {
 		}
 	}
 }
+
+func TestNewTagWithOptions(t *testing.T) {
+	tests := []struct {
+		input reflect.StructField
+		opts  Options
+		want  tag
+	}{
+		{
+			input: reflect.StructField{Name: "MaxRetries"},
+			want:  tag{name: "MaxRetries"},
+		},
+		{
+			input: reflect.StructField{Name: "MaxRetries"},
+			opts:  Options{FieldNaming: SnakeCase},
+			want:  tag{name: "max_retries"},
+		},
+		{
+			input: reflect.StructField{
+				Name: "MaxRetries",
+				Tag:  reflect.StructTag(`ini:",omitempty"`),
+			},
+			opts: Options{FieldNaming: KebabCase},
+			want: tag{name: "max-retries", omitempty: true},
+		},
+		{
+			input: reflect.StructField{
+				Name: "MaxRetries",
+				Tag:  reflect.StructTag(`ini:"retries"`),
+			},
+			opts: Options{FieldNaming: SnakeCase},
+			want: tag{name: "retries"},
+		},
+	}
+
+	for _, test := range tests {
+		got := newTagWithOptions(test.input, test.opts)
+
+		if got != test.want {
+			t.Errorf("%+v != %+v", got, test.want)
+		}
+	}
+}
+
+func TestNamingCase(t *testing.T) {
+	tests := []struct {
+		input string
+		snake string
+		kebab string
+	}{
+		{input: "Name", snake: "name", kebab: "name"},
+		{input: "MaxRetries", snake: "max_retries", kebab: "max-retries"},
+		{input: "HTTPPort", snake: "http_port", kebab: "http-port"},
+		{input: "UserID", snake: "user_id", kebab: "user-id"},
+		{input: "IPv6Address", snake: "i_pv6_address", kebab: "i-pv6-address"},
+		{input: "Level2Cache", snake: "level2_cache", kebab: "level2-cache"},
+		{input: "TLS", snake: "tls", kebab: "tls"},
+	}
+
+	for _, test := range tests {
+		if got := SnakeCase(test.input); got != test.snake {
+			t.Errorf("SnakeCase(%q) = %q, want %q", test.input, got, test.snake)
+		}
+		if got := KebabCase(test.input); got != test.kebab {
+			t.Errorf("KebabCase(%q) = %q, want %q", test.input, got, test.kebab)
+		}
+	}
+}